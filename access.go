@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// canList reviews whether the user may list the resource in the namespace, empty for all namespaces.
+func (d *dumper) canList(ctx context.Context, gvr schema.GroupVersionResource, namespace string) (bool, error) {
+	review := &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      "list",
+				Group:     gvr.Group,
+				Version:   gvr.Version,
+				Resource:  gvr.Resource,
+			},
+		},
+	}
+
+	var result *authorizationv1.SelfSubjectAccessReview
+	err := withRetry(ctx, d.opts.retry, func() (err error) {
+		result, err = d.clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed reviewing access to %v: %v", gvr.String(), err)
+	}
+
+	return result.Status.Allowed, nil
+}
+
+// namespaceRules returns the rules of the user in the namespace and whether they are complete.
+// The rules might be incomplete when the cluster uses an authorizer other than RBAC, e.g. a webhook.
+func (d *dumper) namespaceRules(ctx context.Context, namespace string) ([]authorizationv1.ResourceRule, bool, error) {
+	review := &authorizationv1.SelfSubjectRulesReview{
+		Spec: authorizationv1.SelfSubjectRulesReviewSpec{Namespace: namespace},
+	}
+
+	var result *authorizationv1.SelfSubjectRulesReview
+	err := withRetry(ctx, d.opts.retry, func() (err error) {
+		result, err = d.clientset.AuthorizationV1().SelfSubjectRulesReviews().Create(ctx, review, metav1.CreateOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed reviewing rules in namespace %q: %v", namespace, err)
+	}
+
+	return result.Status.ResourceRules, !result.Status.Incomplete, nil
+}
+
+// rulesAllow reports whether any of the rules allows the verb on all objects of the resource.
+func rulesAllow(rules []authorizationv1.ResourceRule, verb, group, resource string) bool {
+	matches := func(values []string, value string) bool {
+		return slices.Contains(values, "*") || slices.Contains(values, value)
+	}
+
+	for _, rule := range rules {
+		// the rule only grants access to specific objects
+		if len(rule.ResourceNames) > 0 {
+			continue
+		}
+		if matches(rule.Verbs, verb) && matches(rule.APIGroups, group) && matches(rule.Resources, resource) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selectedNamespaces returns the namespaces given with -namespaces, without the ignored ones.
+func (d *dumper) selectedNamespaces() []string {
+	var namespaces []string
+	for _, namespace := range d.opts.wantNamespaces {
+		if namespace == "" || slices.Contains(d.opts.ignoreNamespaces, namespace) {
+			continue
+		}
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces
+}
+
+// listsScope reports whether resources of the scope are listed, matching skipItem: cluster-scoped resources
+// aren't with -clusterscoped=false or -namespaces, namespaced resources aren't with -namespaced=false.
+// Listing them would only fail for users without access to them, e.g. when dumping their own namespaces.
+func (d *dumper) listsScope(namespaced bool) bool {
+	if namespaced {
+		return d.opts.namespaced
+	}
+	restricted := len(d.opts.wantNamespaces) > 0 && d.opts.wantNamespaces[0] != ""
+	return d.opts.clusterscoped && !restricted
+}
+
+// listsCRDs reports whether the CRDs are listed for -crds, which are cluster-scoped.
+func (d *dumper) listsCRDs() bool {
+	return d.opts.crds && d.listsScope(false)
+}
+
+// fallbackNamespaces returns the namespaces to list when listing cluster-wide is forbidden: the ones given
+// with -namespaces, otherwise the ones the user can list, otherwise the default namespace of the context.
+func (d *dumper) fallbackNamespaces(ctx context.Context) []string {
+	if namespaces := d.selectedNamespaces(); len(namespaces) > 0 {
+		return namespaces
+	}
+
+	var namespaceList *corev1.NamespaceList
+	err := withRetry(ctx, d.opts.retry, func() (err error) {
+		namespaceList, err = d.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+		return err
+	})
+	if err != nil {
+		if d.opts.verbosity > 1 {
+			fmt.Fprintf(infoOutput, "%scan't list namespaces, falling back to namespace %q: %v\n", d.log.Prefix(), d.namespace, err)
+		}
+		if slices.Contains(d.opts.ignoreNamespaces, d.namespace) {
+			return nil
+		}
+		return []string{d.namespace}
+	}
+
+	var namespaces []string
+	for _, namespace := range namespaceList.Items {
+		if slices.Contains(d.opts.ignoreNamespaces, namespace.Name) {
+			continue
+		}
+		namespaces = append(namespaces, namespace.Name)
+	}
+	return namespaces
+}
+
+// list lists the resource cluster-wide. When that's forbidden for a namespaced resource, or skipClusterWide is set,
+// each of the fallback namespaces is listed instead and the listed namespaces are returned. Errors of single
+// namespaces are collected, the returned list is nil when none of the namespaces could be listed.
+func (d *dumper) list(ctx context.Context, res apiResource, skipClusterWide bool, fallbackNamespaces func() []string, errs *errorCollector) (*unstructured.UnstructuredList, []string, error) {
+	gvr := res.gvr
+
+	if !skipClusterWide {
+		var list *unstructured.UnstructuredList
+		err := withRetry(ctx, d.opts.retry, func() (err error) {
+			list, err = d.dynamicClient.Resource(gvr).List(ctx, metav1.ListOptions{})
+			return err
+		})
+		if err == nil || !res.namespaced || !apierrors.IsForbidden(err) {
+			return list, nil, err
+		}
+		if len(fallbackNamespaces()) == 0 {
+			return nil, nil, err
+		}
+	}
+
+	if d.opts.verbosity > 1 {
+		fmt.Fprintf(infoOutput, "%slisting %v cluster-wide is forbidden, listing namespaces %v\n", d.log.Prefix(), gvr.String(), strings.Join(fallbackNamespaces(), ","))
+	}
+
+	var (
+		merged *unstructured.UnstructuredList
+		listed []string
+	)
+	for _, namespace := range fallbackNamespaces() {
+		var list *unstructured.UnstructuredList
+		err := withRetry(ctx, d.opts.retry, func() (err error) {
+			list, err = d.dynamicClient.Resource(gvr).Namespace(namespace).List(ctx, metav1.ListOptions{})
+			return err
+		})
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		if err != nil {
+			if d.opts.skipForbidden && apierrors.IsForbidden(err) {
+				continue
+			}
+			d.log.Printf("failed listing %v in namespace %q: %v\n", gvr.String(), namespace, err)
+			errs.add(phaseList, fmt.Sprintf("%v in namespace %v", gvr.String(), namespace), "", err)
+			continue
+		}
+
+		if merged == nil {
+			merged = &unstructured.UnstructuredList{}
+		}
+		merged.Items = append(merged.Items, list.Items...)
+		listed = append(listed, namespace)
+	}
+
+	return merged, listed, nil
+}
+
+// checkPermissions prints a matrix of whether the selected resources can be listed cluster-wide
+// and in each namespace given with -namespaces.
+func (d *dumper) checkPermissions(ctx context.Context, w io.Writer) error {
+	// errors of single group versions are logged by discover
+	resources, err := d.discover(ctx, &report{}, &errorCollector{})
+	if err != nil {
+		return err
+	}
+
+	namespaces := d.selectedNamespaces()
+	type reviewedRules struct {
+		rules    []authorizationv1.ResourceRule
+		complete bool
+	}
+	rules := make(map[string]reviewedRules, len(namespaces))
+	for _, namespace := range namespaces {
+		nsRules, complete, err := d.namespaceRules(ctx, namespace)
+		if err != nil {
+			return err
+		}
+		rules[namespace] = reviewedRules{rules: nsRules, complete: complete}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "GROUP\tVERSION\tRESOURCE\tCLUSTER")
+	for _, namespace := range namespaces {
+		fmt.Fprintf(tw, "\t%v", namespace)
+	}
+	fmt.Fprintln(tw)
+
+	formatAllowed := func(allowed bool) string {
+		if allowed {
+			return "yes"
+		}
+		return "no"
+	}
+
+	// the discovery order isn't stable
+	slices.SortFunc(resources, func(a, b apiResource) int { return compareGVR(a.gvr, b.gvr) })
+
+	for _, res := range resources {
+		allowed, err := d.canList(ctx, res.gvr, "")
+		if err != nil {
+			return err
+		}
+
+		group := res.gvr.Group
+		if group == "" {
+			group = "core"
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v", group, res.gvr.Version, res.gvr.Resource, formatAllowed(allowed))
+
+		for _, namespace := range namespaces {
+			if !res.namespaced {
+				fmt.Fprint(tw, "\t-")
+				continue
+			}
+
+			nsRules := rules[namespace]
+			nsAllowed := rulesAllow(nsRules.rules, "list", res.gvr.Group, res.gvr.Resource)
+			if !nsAllowed && !nsRules.complete {
+				// the rules don't tell, ask the authorizers
+				if nsAllowed, err = d.canList(ctx, res.gvr, namespace); err != nil {
+					return err
+				}
+			}
+			fmt.Fprintf(tw, "\t%v", formatAllowed(nsAllowed))
+		}
+		fmt.Fprintln(tw)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestRulesAllow(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    []authorizationv1.ResourceRule
+		group    string
+		resource string
+		want     bool
+	}{
+		{
+			name:     "no rules",
+			resource: "pods",
+			want:     false,
+		},
+		{
+			name:     "exact",
+			rules:    []authorizationv1.ResourceRule{{Verbs: []string{"get", "list"}, APIGroups: []string{""}, Resources: []string{"pods"}}},
+			resource: "pods",
+			want:     true,
+		},
+		{
+			name:     "wildcards",
+			rules:    []authorizationv1.ResourceRule{{Verbs: []string{"*"}, APIGroups: []string{"*"}, Resources: []string{"*"}}},
+			group:    "apps",
+			resource: "deployments",
+			want:     true,
+		},
+		{
+			name:     "other verb",
+			rules:    []authorizationv1.ResourceRule{{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}}},
+			resource: "pods",
+			want:     false,
+		},
+		{
+			name:     "other group",
+			rules:    []authorizationv1.ResourceRule{{Verbs: []string{"list"}, APIGroups: []string{"apps"}, Resources: []string{"pods"}}},
+			resource: "pods",
+			want:     false,
+		},
+		{
+			name:     "resource names only",
+			rules:    []authorizationv1.ResourceRule{{Verbs: []string{"list"}, APIGroups: []string{""}, Resources: []string{"secrets"}, ResourceNames: []string{"mysecret"}}},
+			resource: "secrets",
+			want:     false,
+		},
+		{
+			name: "any rule",
+			rules: []authorizationv1.ResourceRule{
+				{Verbs: []string{"list"}, APIGroups: []string{""}, Resources: []string{"configmaps"}},
+				{Verbs: []string{"list"}, APIGroups: []string{"apps"}, Resources: []string{"deployments"}},
+			},
+			group:    "apps",
+			resource: "deployments",
+			want:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rulesAllow(tt.rules, "list", tt.group, tt.resource); got != tt.want {
+				t.Errorf("rulesAllow() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	configMaps := apiResource{gvr: schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}, namespaced: true}
+	namespaces := apiResource{gvr: schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}}
+
+	onlyTeamA := func(resource, namespace string) bool { return namespace == "team-a" }
+	onlyNamespaceList := func(resource, namespace string) bool { return resource == "namespaces" || namespace != "" }
+
+	tests := []struct {
+		name            string
+		res             apiResource
+		allowed         func(resource, namespace string) bool
+		wantNamespaces  []string
+		skipClusterWide bool
+		wantItems       []string
+		wantListed      []string
+		wantErr         bool
+		wantErrs        int
+	}{
+		{
+			name:      "cluster-wide",
+			res:       configMaps,
+			allowed:   allowAll,
+			wantItems: []string{"team-a/app", "team-b/other"},
+		},
+		{
+			name:       "default namespace as fallback",
+			res:        configMaps,
+			allowed:    onlyTeamA,
+			wantItems:  []string{"team-a/app"},
+			wantListed: []string{"team-a"},
+		},
+		{
+			name:       "listed namespaces as fallback",
+			res:        configMaps,
+			allowed:    onlyNamespaceList,
+			wantItems:  []string{"team-a/app", "team-b/other"},
+			wantListed: []string{"team-a", "team-b"},
+		},
+		{
+			name:           "forbidden given namespace",
+			res:            configMaps,
+			allowed:        onlyTeamA,
+			wantNamespaces: []string{"team-a", "team-b"},
+			wantItems:      []string{"team-a/app"},
+			wantListed:     []string{"team-a"},
+			wantErrs:       1,
+		},
+		{
+			name:            "skip cluster-wide",
+			res:             configMaps,
+			allowed:         allowAll,
+			wantNamespaces:  []string{"team-b"},
+			skipClusterWide: true,
+			wantItems:       []string{"team-b/other"},
+			wantListed:      []string{"team-b"},
+		},
+		{
+			name:    "cluster-scoped",
+			res:     namespaces,
+			allowed: onlyTeamA,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions()
+			opts.wantNamespaces = tt.wantNamespaces
+			d := newTestDumper(opts, tt.allowed)
+			ctx := context.Background()
+
+			errs := &errorCollector{}
+			list, listed, err := d.list(ctx, tt.res, tt.skipClusterWide, func() []string { return d.fallbackNamespaces(ctx) }, errs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("list() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := len(errs.all()); got != tt.wantErrs {
+				t.Errorf("got %v collected errors, want %v", got, tt.wantErrs)
+			}
+			if !slices.Equal(listed, tt.wantListed) {
+				t.Errorf("got listed namespaces %v, want %v", listed, tt.wantListed)
+			}
+
+			var items []string
+			if list != nil {
+				for _, item := range list.Items {
+					items = append(items, item.GetNamespace()+"/"+item.GetName())
+				}
+			}
+			slices.Sort(items)
+			if !slices.Equal(items, tt.wantItems) {
+				t.Errorf("got items %v, want %v", items, tt.wantItems)
+			}
+		})
+	}
+}
+
+func TestCheckPermissions(t *testing.T) {
+	opts := testOptions()
+	opts.wantNamespaces = []string{"team-a", "team-b"}
+	// secrets are only allowed in team-a, the rest cluster-wide, cluster-scoped resources aren't dumped with namespaces
+	d := newTestDumper(opts, func(resource, namespace string) bool {
+		return resource != "secrets" || namespace == "team-a"
+	})
+
+	var out strings.Builder
+	if err := d.checkPermissions(context.Background(), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"GROUP VERSION RESOURCE CLUSTER team-a team-b",
+		"core v1 configmaps yes yes yes",
+		"core v1 pods yes yes yes",
+		"core v1 secrets no yes no",
+		"apps v1 deployments yes yes yes",
+	}
+	var got []string
+	for line := range strings.Lines(out.String()) {
+		got = append(got, strings.Join(strings.Fields(line), " "))
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got output\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	chartTemplatesDir = "templates"
+	chartVersion      = "0.1.0"
+)
+
+// helmignore excludes the files written next to the manifests from the chart,
+// Helm would try to render them otherwise.
+const helmignore = `# written by kubedump next to the manifests
+*.log
+*.events.txt
+*.data/
+`
+
+// chartMetadata is the content of a Chart.yaml.
+type chartMetadata struct {
+	APIVersion  string `json:"apiVersion"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	Version     string `json:"version"`
+}
+
+// Placeholders for lifted values, they are replaced by template actions after marshalling, as the
+// marshaller would quote the actions otherwise. They contain the hex encoded path of the value.
+var placeholderRegexp = regexp.MustCompile(`__KUBEDUMP_(VALUE|QUOTED)_([0-9a-f]+)__`)
+
+func placeholder(quoted bool, path []string) string {
+	kind := "VALUE"
+	if quoted {
+		kind = "QUOTED"
+	}
+	encoded, _ := json.Marshal(path) // can't fail for strings
+	return fmt.Sprintf("__KUBEDUMP_%s_%s__", kind, hex.EncodeToString(encoded))
+}
+
+// helmTemplate escapes the template delimiters of the manifest and replaces the placeholders
+// of the lifted values with template actions.
+func helmTemplate(manifest []byte) []byte {
+	escaped := strings.ReplaceAll(string(manifest), "{{", `{{ "{{" }}`)
+
+	return []byte(placeholderRegexp.ReplaceAllStringFunc(escaped, func(match string) string {
+		groups := placeholderRegexp.FindStringSubmatch(match)
+		decoded, err := hex.DecodeString(groups[2])
+		if err != nil {
+			return match
+		}
+		var path []string
+		if err := json.Unmarshal(decoded, &path); err != nil {
+			return match
+		}
+
+		action := "index .Values"
+		for _, key := range path {
+			action += " " + strconv.Quote(key)
+		}
+		if groups[1] == "QUOTED" {
+			action += " | quote"
+		}
+		return "{{ " + action + " }}"
+	}))
+}
+
+// chartValues collects the values lifted from the manifests of each chart.
+type chartValues struct {
+	mu     sync.Mutex
+	values map[string]map[string]any // by namespace, empty for cluster-scoped
+}
+
+func newChartValues() *chartValues {
+	return &chartValues{values: make(map[string]map[string]any)}
+}
+
+// podSpecPaths are the paths of the pod specs in the workload resources.
+var podSpecPaths = [][]string{
+	{"spec", "template", "spec"},                        // e.g. deployments, statefulsets, jobs
+	{"spec", "jobTemplate", "spec", "template", "spec"}, // cronjobs
+}
+
+// lift replaces the replicas, the image tags and the resource limits of the item with placeholders
+// and adds their values to the chart of the item's namespace, as '<resource>.<name>.<field>'.
+func (c *chartValues) lift(resourceAndGroup string, item unstructured.Unstructured) {
+	objPath := []string{resourceAndGroup, item.GetName()}
+	lifted := make(map[string]any)
+
+	if replicas, found, _ := unstructured.NestedInt64(item.Object, "spec", "replicas"); found {
+		setNestedValue(lifted, []string{"replicas"}, replicas)
+		unstructured.SetNestedField(item.Object, placeholder(false, slices.Concat(objPath, []string{"replicas"})), "spec", "replicas")
+	}
+
+	specPaths := podSpecPaths
+	if item.GetKind() == "Pod" && item.GetAPIVersion() == "v1" {
+		specPaths = [][]string{{"spec"}}
+	}
+	for _, specPath := range specPaths {
+		for _, field := range []string{"initContainers", "containers"} {
+			containers, found, _ := unstructured.NestedSlice(item.Object, slices.Concat(specPath, []string{field})...)
+			if !found {
+				continue
+			}
+			for i, container := range containers {
+				container, ok := container.(map[string]any)
+				if !ok {
+					continue
+				}
+				liftContainer(container, lifted, slices.Concat(objPath, []string{field}))
+				containers[i] = container
+			}
+			unstructured.SetNestedSlice(item.Object, containers, slices.Concat(specPath, []string{field})...)
+		}
+	}
+
+	if len(lifted) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.values[item.GetNamespace()] == nil {
+		c.values[item.GetNamespace()] = make(map[string]any)
+	}
+	setNestedValue(c.values[item.GetNamespace()], objPath, lifted)
+}
+
+// liftContainer lifts the image tag and the resource limits of the container
+// to '<field>.<container>.tag' and '<field>.<container>.limits.<resource>'.
+func liftContainer(container map[string]any, lifted map[string]any, fieldPath []string) {
+	name, _, _ := unstructured.NestedString(container, "name")
+	if name == "" {
+		return
+	}
+	containerPath := slices.Concat(fieldPath, []string{name})
+	valuesPath := containerPath[2:] // relative to the object
+
+	image, _, _ := unstructured.NestedString(container, "image")
+	if repository, tag, ok := splitImageTag(image); ok {
+		setNestedValue(lifted, slices.Concat(valuesPath, []string{"tag"}), tag)
+		container["image"] = repository + ":" + placeholder(false, slices.Concat(containerPath, []string{"tag"}))
+	}
+
+	limits, _, _ := unstructured.NestedMap(container, "resources", "limits")
+	for resource, limit := range limits {
+		if _, ok := limit.(string); !ok {
+			continue
+		}
+		setNestedValue(lifted, slices.Concat(valuesPath, []string{"limits", resource}), limit)
+		limits[resource] = placeholder(true, slices.Concat(containerPath, []string{"limits", resource}))
+	}
+	if len(limits) > 0 {
+		unstructured.SetNestedMap(container, limits, "resources", "limits")
+	}
+}
+
+// splitImageTag splits the image into repository and tag, images without a tag or with a digest aren't split.
+func splitImageTag(image string) (repository, tag string, ok bool) {
+	if strings.Contains(image, "@") {
+		return "", "", false
+	}
+	i := strings.LastIndex(image, ":")
+	// a colon before the last slash separates the port of the registry
+	if i < 0 || i < strings.LastIndex(image, "/") || i == len(image)-1 {
+		return "", "", false
+	}
+	return image[:i], image[i+1:], true
+}
+
+// setNestedValue sets the value in the nested maps, creating the missing ones.
+func setNestedValue(m map[string]any, path []string, value any) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := m[key].(map[string]any)
+		if !ok {
+			next = make(map[string]any)
+			m[key] = next
+		}
+		m = next
+	}
+	m[path[len(path)-1]] = value
+}
+
+// writeCharts writes the Chart.yaml, the values.yaml and the .helmignore of each namespace
+// and of the cluster-scoped manifests, the templates are written by the dump.
+func writeCharts(w fileWriter, outDir string, values *chartValues) error {
+	type chart struct{ dir, name, namespace, description string }
+
+	charts := []chart{
+		{filepath.Join(outDir, "clusterscoped"), "clusterscoped", "", "Cluster-scoped objects"},
+	}
+	namespaces, err := subdirs(filepath.Join(outDir, "namespaced"))
+	if err != nil {
+		return err
+	}
+	for _, namespace := range namespaces {
+		charts = append(charts, chart{
+			filepath.Join(outDir, "namespaced", namespace), namespace, namespace, fmt.Sprintf("Objects of the namespace %v", namespace),
+		})
+	}
+
+	for _, chart := range charts {
+		templates, err := subdirs(filepath.Join(chart.dir, chartTemplatesDir))
+		if err != nil {
+			return err
+		}
+		if len(templates) == 0 {
+			continue
+		}
+
+		metadata, err := yaml.Marshal(chartMetadata{
+			APIVersion:  "v2",
+			Name:        chart.name,
+			Description: chart.description,
+			Type:        "application",
+			Version:     chartVersion,
+		})
+		if err != nil {
+			return fmt.Errorf("failed marshalling chart: %v", err)
+		}
+
+		values.mu.Lock()
+		chartValues, err := yaml.Marshal(values.values[chart.namespace])
+		values.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("failed marshalling values: %v", err)
+		}
+
+		for filename, content := range map[string][]byte{
+			"Chart.yaml":  metadata,
+			"values.yaml": chartValues,
+			".helmignore": []byte(helmignore),
+		} {
+			if err := w.writeFile(filepath.Join(chart.dir, filename), content); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+func TestHelmTemplate(t *testing.T) {
+	item := unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]any{"name": "web", "namespace": "default", "annotations": map[string]any{"template": "{{ .Name }}"}},
+		"spec": map[string]any{
+			"replicas": int64(3),
+			"template": map[string]any{"spec": map[string]any{
+				"containers": []any{map[string]any{
+					"name":      "web",
+					"image":     "registry:5000/nginx:1.25",
+					"resources": map[string]any{"limits": map[string]any{"cpu": "1"}},
+				}},
+			}},
+		},
+	}}
+	original, err := yaml.Marshal(item.Object)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := newChartValues()
+	values.lift("deployments.apps", item)
+
+	manifest, err := yaml.Marshal(item.Object)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// render like Helm, which provides quote by sprig
+	tmpl, err := template.New("manifest").Funcs(template.FuncMap{"quote": strconv.Quote}).Parse(string(helmTemplate(manifest)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, map[string]any{"Values": values.values["default"]}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the rendered manifest equals the original one
+	var got, want map[string]any
+	if err := yaml.Unmarshal(rendered.Bytes(), &got); err != nil {
+		t.Fatalf("failed unmarshalling rendered manifest: %v\n%s", err, rendered.String())
+	}
+	if err := yaml.Unmarshal(original, &want); err != nil {
+		t.Fatal(err)
+	}
+	gotBytes, _ := yaml.Marshal(got)
+	wantBytes, _ := yaml.Marshal(want)
+	if !bytes.Equal(gotBytes, wantBytes) {
+		t.Errorf("got rendered:\n%s\nwant:\n%s", gotBytes, wantBytes)
+	}
+}
+
+func TestSplitImageTag(t *testing.T) {
+	tests := []struct {
+		image          string
+		wantRepository string
+		wantTag        string
+		wantOK         bool
+	}{
+		{image: "nginx:1.25", wantRepository: "nginx", wantTag: "1.25", wantOK: true},
+		{image: "registry:5000/nginx:1.25", wantRepository: "registry:5000/nginx", wantTag: "1.25", wantOK: true},
+		{image: "registry:5000/nginx"},
+		{image: "nginx"},
+		{image: "nginx@sha256:abc"},
+		{image: "nginx:1.25@sha256:abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			repository, tag, ok := splitImageTag(tt.image)
+			if repository != tt.wantRepository || tag != tt.wantTag || ok != tt.wantOK {
+				t.Errorf("got %q, %q, %v, want %q, %q, %v", repository, tag, ok, tt.wantRepository, tt.wantTag, tt.wantOK)
+			}
+		})
+	}
+}
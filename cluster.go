@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cluster is one of several clusters dumped in a single run.
+type cluster struct {
+	context string
+	dir     string  // subdirectory of the output directory
+	dumper  *dumper // nil when the setup failed
+	err     error   // setup error, the cluster is not dumped
+}
+
+// multiDumper dumps several clusters concurrently, each into its own subdirectory.
+type multiDumper struct {
+	clusters []cluster
+	opts     options
+	writer   fileWriter
+	metrics  *metrics
+}
+
+// clustersReport is the combined report of a multi-cluster dump.
+type clustersReport struct {
+	Version   string          `json:"version"`
+	Commit    string          `json:"commit"`
+	Start     time.Time       `json:"start"`
+	End       time.Time       `json:"end"`
+	Complete  bool            `json:"complete"` // false if any cluster is incomplete
+	Objects   uint64          `json:"objects"`
+	Bytes     uint64          `json:"bytes"`
+	Unchanged uint64          `json:"unchanged"`
+	Removed   uint64          `json:"removed"`
+	Logs      uint64          `json:"logs"`
+	LogBytes  uint64          `json:"logBytes"`
+	Clusters  []clusterReport `json:"clusters"`
+}
+
+type clusterReport struct {
+	Context string  `json:"context"`
+	Dir     string  `json:"dir"`
+	Failed  bool    `json:"failed"`
+	Error   string  `json:"error,omitempty"` // e.g. invalid credentials in the kubeconfig
+	Report  *report `json:"report,omitempty"`
+}
+
+// newMultiDumper creates the dumpers of the contexts matching the patterns, or of all contexts.
+// A context whose client can't be created is reported as failed when dumping, without affecting the other ones.
+func newMultiDumper(patterns string, allContexts bool, client clientOptions, opts options, writer fileWriter, m *metrics) (*multiDumper, error) {
+	contexts, err := kubeconfigContexts(client)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading kubeconfig: %v", err)
+	}
+
+	if !allContexts {
+		contexts, err = matchContexts(contexts, strings.Split(patterns, ","))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(contexts) == 0 {
+		return nil, errors.New("no contexts found")
+	}
+
+	dirs, err := contextDirs(contexts)
+	if err != nil {
+		return nil, err
+	}
+
+	// reports and metrics are printed and pushed once for all clusters
+	clusterOpts := opts
+	clusterOpts.printReport = false
+	clusterOpts.pushgateway = ""
+
+	md := &multiDumper{opts: opts, writer: writer, metrics: m}
+	for i, name := range contexts {
+		logger := log.New(log.Writer(), fmt.Sprintf("[%v] ", name), log.Flags()|log.Lmsgprefix)
+		d, err := newDumper(name, client, clusterOpts, writer, m, logger)
+		md.clusters = append(md.clusters, cluster{context: name, dir: dirs[i], dumper: d, err: err})
+	}
+
+	return md, nil
+}
+
+// dumpTo dumps all clusters into their subdirectories of outDir and returns the combined report
+// and whether any cluster failed. A failing cluster doesn't affect the dumps of the other ones.
+func (m *multiDumper) dumpTo(ctx context.Context, outDir string) (*clustersReport, bool) {
+	combined := &clustersReport{
+		Version:  version,
+		Commit:   commit,
+		Start:    time.Now(),
+		Clusters: make([]clusterReport, len(m.clusters)),
+	}
+
+	var waitGroup sync.WaitGroup
+	for i, c := range m.clusters {
+		combined.Clusters[i] = clusterReport{Context: c.context, Dir: c.dir}
+
+		if c.err != nil {
+			log.Printf("[%v] skipping cluster: %v\n", c.context, c.err)
+			combined.Clusters[i].Failed = true
+			combined.Clusters[i].Error = c.err.Error()
+			continue
+		}
+
+		waitGroup.Add(1)
+		go func(i int, c cluster) {
+			defer waitGroup.Done()
+
+			dumpReport, failed := c.dumper.dumpTo(ctx, filepath.Join(outDir, c.dir))
+			combined.Clusters[i].Failed = failed
+			combined.Clusters[i].Report = dumpReport
+		}(i, c)
+	}
+	waitGroup.Wait()
+
+	combined.End = time.Now()
+	combined.Complete = true
+
+	var failed bool
+	for _, c := range combined.Clusters {
+		failed = failed || c.Failed
+		if c.Report == nil {
+			continue
+		}
+		combined.Complete = combined.Complete && c.Report.Complete
+		combined.Objects += c.Report.Objects
+		combined.Bytes += c.Report.Bytes
+		combined.Unchanged += c.Report.Unchanged
+		combined.Removed += c.Report.Removed
+		combined.Logs += c.Report.Logs
+		combined.LogBytes += c.Report.LogBytes
+	}
+
+	// errors of the combined files, which count like the ones of a cluster
+	var errs errorCollector
+	if m.opts.report {
+		if err := writeReport(m.writer, outDir, combined); err != nil {
+			log.Printf("failed writing combined report: %v\n", err)
+			errs.add(phaseWrite, reportFilename, "", err)
+		}
+	}
+	if m.opts.printReport {
+		reportBytes, err := combined.marshal()
+		if err != nil {
+			log.Printf("failed marshalling combined report: %v\n", err)
+		} else {
+			fmt.Println(string(reportBytes))
+		}
+	}
+	// covers the checksums of the clusters as well
+	if m.opts.checksums {
+		if err := writeChecksums(m.writer, outDir, m.opts.signKey); err != nil {
+			log.Printf("failed writing combined checksums: %v\n", err)
+			errs.add(phaseWrite, checksumsFilename, "", err)
+		}
+	}
+	failed = failed || errs.failed(m.opts.failOn)
+	if m.opts.pushgateway != "" {
+		if err := pushMetrics(m.opts.pushgateway, m.metrics); err != nil {
+			log.Printf("failed pushing metrics to %q: %v\n", m.opts.pushgateway, err)
+		}
+	}
+
+	for _, c := range combined.Clusters {
+		if c.Failed {
+			log.Printf("dump of context %q failed\n", c.Context)
+		}
+	}
+
+	return combined, failed
+}
+
+func (r *clustersReport) marshal() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// checkPermissions prints the permission matrix of each cluster and returns whether any check failed.
+func (m *multiDumper) checkPermissions(ctx context.Context, w io.Writer) bool {
+	var failed bool
+	for _, c := range m.clusters {
+		fmt.Fprintf(w, "context %q:\n", c.context)
+
+		err := c.err
+		if err == nil {
+			err = c.dumper.checkPermissions(ctx, w)
+		}
+		if err != nil {
+			log.Printf("[%v] failed checking permissions: %v\n", c.context, err)
+			failed = true
+		}
+		fmt.Fprintln(w)
+	}
+	return failed
+}
+
+// dryRun prints the resources which would be dumped of each cluster and returns whether any failed.
+func (m *multiDumper) dryRun(ctx context.Context, w io.Writer) bool {
+	var failed bool
+	for _, c := range m.clusters {
+		fmt.Fprintf(w, "context %q:\n", c.context)
+
+		err := c.err
+		if err == nil {
+			err = c.dumper.dryRun(ctx, w)
+		}
+		if err != nil {
+			log.Printf("[%v] failed planning the dump: %v\n", c.context, err)
+			failed = true
+		}
+		fmt.Fprintln(w)
+	}
+	return failed
+}
+
+// kubeconfigContexts returns the sorted names of all contexts in the kubeconfig.
+func kubeconfigContexts(client clientOptions) ([]string, error) {
+	rawConfig, err := clientConfig("", client).RawConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var contexts []string
+	for name := range rawConfig.Contexts {
+		contexts = append(contexts, name)
+	}
+	slices.Sort(contexts)
+	return contexts, nil
+}
+
+// matchContexts returns the contexts matching any of the glob patterns ('*' and '?'),
+// and an error if a pattern doesn't match any context.
+func matchContexts(contexts, patterns []string) ([]string, error) {
+	matched := make(map[string]bool)
+
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+
+		re := globToRegexp(pattern)
+		var found bool
+		for _, name := range contexts {
+			if re.MatchString(name) {
+				matched[name] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no context matches %q", pattern)
+		}
+	}
+
+	var result []string
+	for _, name := range contexts {
+		if matched[name] {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
+
+// globToRegexp converts a glob pattern to a regular expression. Unlike path.Match,
+// '*' also matches '/', which is common in context names (e.g. EKS ARNs).
+func globToRegexp(pattern string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(pattern)
+	quoted = strings.ReplaceAll(quoted, `\*`, ".*")
+	quoted = strings.ReplaceAll(quoted, `\?`, ".")
+	return regexp.MustCompile("^" + quoted + "$")
+}
+
+// contextDirs returns the directory names of the contexts, with characters
+// which are invalid in paths or on Windows replaced.
+func contextDirs(contexts []string) ([]string, error) {
+	replacer := strings.NewReplacer("/", "_", `\`, "_", ":", "_", "*", "_", "?", "_", `"`, "_", "<", "_", ">", "_", "|", "_")
+
+	var (
+		dirs = make([]string, len(contexts))
+		seen = make(map[string]string)
+	)
+	for i, name := range contexts {
+		dir := replacer.Replace(name)
+		if dir == "" || dir == "." || dir == ".." {
+			return nil, fmt.Errorf("invalid directory name %q for context %q", dir, name)
+		}
+		if other, ok := seen[dir]; ok {
+			return nil, fmt.Errorf("contexts %q and %q would be dumped into the same directory %q", other, name, dir)
+		}
+		seen[dir] = name
+		dirs[i] = dir
+	}
+	return dirs, nil
+}
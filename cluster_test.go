@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchContexts(t *testing.T) {
+	contexts := []string{
+		"arn:aws:eks:eu-west-1:123456789012:cluster/prod",
+		"dev",
+		"prod-eu",
+		"prod-us",
+		"staging",
+	}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "exact",
+			patterns: []string{"dev"},
+			want:     []string{"dev"},
+		},
+		{
+			name:     "glob",
+			patterns: []string{"prod-*"},
+			want:     []string{"prod-eu", "prod-us"},
+		},
+		{
+			name:     "glob matching slash",
+			patterns: []string{"arn:*/prod"},
+			want:     []string{"arn:aws:eks:eu-west-1:123456789012:cluster/prod"},
+		},
+		{
+			name:     "question mark",
+			patterns: []string{"prod-?u"},
+			want:     []string{"prod-eu"},
+		},
+		{
+			name:     "multiple patterns in kubeconfig order without duplicates",
+			patterns: []string{"staging", "prod-*", "prod-eu"},
+			want:     []string{"prod-eu", "prod-us", "staging"},
+		},
+		{
+			name:     "no partial match",
+			patterns: []string{"prod"},
+			wantErr:  true,
+		},
+		{
+			name:     "regexp characters are literal",
+			patterns: []string{"prod.eu"},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := matchContexts(contexts, tt.patterns)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("matchContexts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matchContexts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContextDirs(t *testing.T) {
+	tests := []struct {
+		name     string
+		contexts []string
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "plain",
+			contexts: []string{"dev", "prod"},
+			want:     []string{"dev", "prod"},
+		},
+		{
+			name:     "invalid characters",
+			contexts: []string{"arn:aws:eks:eu-west-1:123456789012:cluster/prod", "admin@kind"},
+			want:     []string{"arn_aws_eks_eu-west-1_123456789012_cluster_prod", "admin@kind"},
+		},
+		{
+			name:     "collision",
+			contexts: []string{"team/dev", "team_dev"},
+			wantErr:  true,
+		},
+		{
+			name:     "dot",
+			contexts: []string{".."},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := contextDirs(tt.contexts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("contextDirs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("contextDirs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
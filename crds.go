@@ -0,0 +1,219 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+const crdIndexFilename = "crds.yaml"
+
+var crdsGVR = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+// customResourceDefinition contains the fields of a CRD relevant for the index.
+type customResourceDefinition struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Group string `json:"group"`
+		Names struct {
+			Plural string `json:"plural"`
+		} `json:"names"`
+		Versions []struct {
+			Name    string `json:"name"`
+			Served  bool   `json:"served"`
+			Storage bool   `json:"storage"`
+		} `json:"versions"`
+	} `json:"spec"`
+}
+
+func (crd customResourceDefinition) storageVersion() string {
+	for _, v := range crd.Spec.Versions {
+		if v.Storage {
+			return v.Name
+		}
+	}
+	return ""
+}
+
+// crdIndex collects the dumped custom resources and their CRDs.
+type crdIndex struct {
+	mu        sync.Mutex
+	crds      map[schema.GroupResource]customResourceDefinition
+	items     map[schema.GroupResource]unstructured.Unstructured // the CRD manifests
+	versions  map[schema.GroupResource]string                    // dumped version
+	instances map[schema.GroupResource][]crdIndexInstance
+}
+
+// crdIndexFile is the content of the index file.
+type crdIndexFile struct {
+	CRDs []crdIndexEntry `json:"crds"`
+}
+
+type crdIndexEntry struct {
+	Name           string             `json:"name"`
+	Group          string             `json:"group"`
+	Resource       string             `json:"resource"`
+	StorageVersion string             `json:"storageVersion"`
+	ServedVersions []string           `json:"servedVersions"`
+	DumpedVersion  string             `json:"dumpedVersion"`
+	Instances      []crdIndexInstance `json:"instances"`
+}
+
+type crdIndexInstance struct {
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// listCRDs lists the CRDs of the cluster.
+func (d *dumper) listCRDs(ctx context.Context) (*crdIndex, error) {
+	var list *unstructured.UnstructuredList
+	err := withRetry(ctx, d.opts.retry, func() (err error) {
+		list, err = d.dynamicClient.Resource(crdsGVR).List(ctx, metav1.ListOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed listing %v: %v", crdsGVR.String(), err)
+	}
+
+	return newCRDIndex(list.Items)
+}
+
+func newCRDIndex(items []unstructured.Unstructured) (*crdIndex, error) {
+	index := &crdIndex{
+		crds:      make(map[schema.GroupResource]customResourceDefinition),
+		items:     make(map[schema.GroupResource]unstructured.Unstructured),
+		versions:  make(map[schema.GroupResource]string),
+		instances: make(map[schema.GroupResource][]crdIndexInstance),
+	}
+	for _, item := range items {
+		var crd customResourceDefinition
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &crd); err != nil {
+			return nil, fmt.Errorf("failed converting CRD %q: %v", item.GetName(), err)
+		}
+
+		gr := schema.GroupResource{Group: crd.Spec.Group, Resource: crd.Spec.Names.Plural}
+		index.crds[gr] = crd
+		index.items[gr] = item
+	}
+	return index, nil
+}
+
+// storageVersions keeps a single version of each custom resource: the storage version if it's selected,
+// otherwise the first selected version, which is returned as a warning. The other versions are returned
+// separately, dumping them would overwrite the same files in a random order.
+func (index *crdIndex) storageVersions(resources []apiResource) (kept, skipped []apiResource, warnings []string) {
+	selectedVersions := make(map[schema.GroupResource][]string)
+	for _, res := range resources {
+		gr := res.gvr.GroupResource()
+		if _, ok := index.crds[gr]; ok {
+			selectedVersions[gr] = append(selectedVersions[gr], res.gvr.Version)
+		}
+	}
+
+	dumpedVersions := make(map[schema.GroupResource]string, len(selectedVersions))
+	for gr, versions := range selectedVersions {
+		storage := index.crds[gr].storageVersion()
+		if slices.Contains(versions, storage) {
+			dumpedVersions[gr] = storage
+			continue
+		}
+		dumpedVersions[gr] = versions[0]
+		warnings = append(warnings, fmt.Sprintf("%v is dumped in version %v instead of the storage version %v", gr.String(), versions[0], storage))
+	}
+	slices.Sort(warnings)
+
+	for _, res := range resources {
+		gr := res.gvr.GroupResource()
+		if version, ok := dumpedVersions[gr]; ok && version != res.gvr.Version {
+			skipped = append(skipped, res)
+			continue
+		}
+		kept = append(kept, res)
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.versions = dumpedVersions
+	return kept, skipped, warnings
+}
+
+// isCustom reports whether the resource is defined by a CRD.
+func (index *crdIndex) isCustom(gvr schema.GroupVersionResource) bool {
+	_, ok := index.crds[gvr.GroupResource()]
+	return ok
+}
+
+// addInstance records a dumped custom resource.
+func (index *crdIndex) addInstance(gvr schema.GroupVersionResource, namespace, name string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	gr := gvr.GroupResource()
+	index.instances[gr] = append(index.instances[gr], crdIndexInstance{Namespace: namespace, Name: name})
+}
+
+// entries returns the index entries of the dumped custom resources, sorted by the name of the CRD.
+func (index *crdIndex) entries() []crdIndexEntry {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	var entries []crdIndexEntry
+	for gr, version := range index.versions {
+		crd := index.crds[gr]
+
+		var served []string
+		for _, v := range crd.Spec.Versions {
+			if v.Served {
+				served = append(served, v.Name)
+			}
+		}
+
+		instances := append([]crdIndexInstance{}, index.instances[gr]...)
+		slices.SortFunc(instances, func(a, b crdIndexInstance) int {
+			return cmp.Or(cmp.Compare(a.Namespace, b.Namespace), cmp.Compare(a.Name, b.Name))
+		})
+
+		entries = append(entries, crdIndexEntry{
+			Name:           crd.Metadata.Name,
+			Group:          gr.Group,
+			Resource:       gr.Resource,
+			StorageVersion: crd.storageVersion(),
+			ServedVersions: served,
+			DumpedVersion:  version,
+			Instances:      instances,
+		})
+	}
+	slices.SortFunc(entries, func(a, b crdIndexEntry) int { return cmp.Compare(a.Name, b.Name) })
+	return entries
+}
+
+// write writes the CRDs of the dumped custom resources, regardless of the filters, and the index.
+func (index *crdIndex) write(w fileWriter, outDir string, opts options, names *namer) error {
+	entries := index.entries()
+
+	resourceAndGroup := fmt.Sprintf("%s.%s", crdsGVR.Resource, crdsGVR.Group)
+	for _, entry := range entries {
+		item := index.items[schema.GroupResource{Group: entry.Group, Resource: entry.Resource}]
+		// written by the dump already when not filtered, the content is the same
+		manifestPath := names.objectPath(outDir, resourceAndGroup, "", item.GetName())
+		if _, err := writeYAML(w, manifestPath, *item.DeepCopy(), opts); err != nil {
+			return fmt.Errorf("failed writing CRD %q: %v", entry.Name, err)
+		}
+	}
+
+	indexBytes, err := yaml.Marshal(crdIndexFile{CRDs: entries})
+	if err != nil {
+		return fmt.Errorf("failed marshalling CRD index: %v", err)
+	}
+	return w.writeFile(filepath.Join(outDir, crdIndexFilename), indexBytes)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestCRDIndexStorageVersions(t *testing.T) {
+	newCRD := func(group, plural string, versions ...map[string]any) unstructured.Unstructured {
+		var vs []any
+		for _, v := range versions {
+			vs = append(vs, v)
+		}
+		return unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "apiextensions.k8s.io/v1",
+			"kind":       "CustomResourceDefinition",
+			"metadata":   map[string]any{"name": plural + "." + group},
+			"spec": map[string]any{
+				"group":    group,
+				"names":    map[string]any{"plural": plural},
+				"versions": vs,
+			},
+		}}
+	}
+
+	index, err := newCRDIndex([]unstructured.Unstructured{
+		newCRD("example.com", "widgets",
+			map[string]any{"name": "v1", "served": true, "storage": false},
+			map[string]any{"name": "v1beta1", "served": true, "storage": true},
+		),
+		newCRD("example.com", "gadgets",
+			map[string]any{"name": "v2", "served": true, "storage": false},
+			map[string]any{"name": "v1", "served": false, "storage": true},
+		),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		pods           = apiResource{gvr: schema.GroupVersionResource{Version: "v1", Resource: "pods"}, namespaced: true}
+		widgetsV1      = apiResource{gvr: schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}, namespaced: true}
+		widgetsV1beta1 = apiResource{gvr: schema.GroupVersionResource{Group: "example.com", Version: "v1beta1", Resource: "widgets"}, namespaced: true}
+		gadgetsV2      = apiResource{gvr: schema.GroupVersionResource{Group: "example.com", Version: "v2", Resource: "gadgets"}}
+	)
+
+	kept, skipped, warnings := index.storageVersions([]apiResource{pods, widgetsV1, widgetsV1beta1, gadgetsV2})
+
+	if want := []apiResource{pods, widgetsV1beta1, gadgetsV2}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("got kept %v, want %v", kept, want)
+	}
+	if want := []apiResource{widgetsV1}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("got skipped %v, want %v", skipped, want)
+	}
+	if want := []string{"gadgets.example.com is dumped in version v2 instead of the storage version v1"}; !reflect.DeepEqual(warnings, want) {
+		t.Errorf("got warnings %v, want %v", warnings, want)
+	}
+
+	index.addInstance(widgetsV1beta1.gvr, "default", "w2")
+	index.addInstance(widgetsV1beta1.gvr, "default", "w1")
+
+	wantEntries := []crdIndexEntry{
+		{
+			Name:           "gadgets.example.com",
+			Group:          "example.com",
+			Resource:       "gadgets",
+			StorageVersion: "v1",
+			ServedVersions: []string{"v2"},
+			DumpedVersion:  "v2",
+			Instances:      []crdIndexInstance{},
+		},
+		{
+			Name:           "widgets.example.com",
+			Group:          "example.com",
+			Resource:       "widgets",
+			StorageVersion: "v1beta1",
+			ServedVersions: []string{"v1", "v1beta1"},
+			DumpedVersion:  "v1beta1",
+			Instances:      []crdIndexInstance{{Namespace: "default", Name: "w1"}, {Namespace: "default", Name: "w2"}},
+		},
+	}
+	if got := index.entries(); !reflect.DeepEqual(got, wantEntries) {
+		t.Errorf("got entries %+v, want %+v", got, wantEntries)
+	}
+}
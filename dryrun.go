@@ -0,0 +1,137 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// dryRun prints the discovered resources with whether they would be dumped and why not, without writing any files.
+// With -dry-run-count, the objects of the dumped resources are counted before filtering them by labels and namespaces.
+func (d *dumper) dryRun(ctx context.Context, w io.Writer) error {
+	// errors of single group versions are logged by discoverAll
+	planned, err := d.discoverAll(ctx, &errorCollector{})
+	if err != nil {
+		return err
+	}
+
+	if d.listsCRDs() {
+		crds, err := d.listCRDs(ctx)
+		if err != nil {
+			return err
+		}
+		_, skipped, warnings := crds.storageVersions(selectedResources(planned))
+		for i, res := range planned {
+			if res.reason == "" && slices.Contains(skipped, res.apiResource) {
+				planned[i].reason = skipReasonStorageVersion
+			}
+		}
+		for _, warning := range warnings {
+			d.log.Printf("warning: %v\n", warning)
+		}
+	}
+
+	// the discovery order isn't stable, skipped groups are printed first in their group
+	slices.SortStableFunc(planned, func(a, b plannedResource) int { return compareGVR(a.gvr, b.gvr) })
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "GROUP\tVERSION\tRESOURCE\tSCOPE\tDUMP\tREASON")
+	if d.opts.dryRunCount {
+		fmt.Fprint(tw, "\tOBJECTS")
+	}
+	fmt.Fprintln(tw)
+
+	for _, res := range planned {
+		if res.reason == "" && d.opts.skipForbidden && !res.namespaced {
+			// namespaced resources would be listed in the namespaces instead
+			allowed, err := d.canList(ctx, res.gvr, "")
+			if err != nil {
+				return err
+			}
+			if !allowed {
+				res.reason = skipReasonForbidden
+			}
+		}
+
+		group, version, resource, scope := res.gvr.Group, res.gvr.Version, res.gvr.Resource, "cluster"
+		if group == "" {
+			group = "core"
+		}
+		switch {
+		case resource == "":
+			// the whole group is skipped
+			version, resource, scope = "*", "*", "-"
+		case res.namespaced:
+			scope = "namespaced"
+		}
+
+		dump, reason := "yes", "-"
+		if res.reason != "" {
+			dump, reason = "no", res.reason
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v", group, version, resource, scope, dump, reason)
+
+		if d.opts.dryRunCount {
+			count := "-"
+			if res.reason == "" {
+				count = d.countObjects(ctx, res.gvr)
+			}
+			fmt.Fprintf(tw, "\t%v", count)
+		}
+		fmt.Fprintln(tw)
+	}
+
+	return tw.Flush()
+}
+
+// compareGVR orders by group, version and resource.
+func compareGVR(a, b schema.GroupVersionResource) int {
+	return cmp.Or(cmp.Compare(a.Group, b.Group), cmp.Compare(a.Version, b.Version), cmp.Compare(a.Resource, b.Resource))
+}
+
+// selectedResources returns the planned resources which aren't skipped.
+func selectedResources(planned []plannedResource) []apiResource {
+	var selected []apiResource
+	for _, res := range planned {
+		if res.reason == "" {
+			selected = append(selected, res.apiResource)
+		}
+	}
+	return selected
+}
+
+// countObjects returns the number of objects of the resource, using the remaining item count of a list
+// limited to a single object. It's '?' when the count is unknown, e.g. as listing failed.
+func (d *dumper) countObjects(ctx context.Context, gvr schema.GroupVersionResource) string {
+	var list *unstructured.UnstructuredList
+	err := withRetry(ctx, d.opts.retry, func() (err error) {
+		list, err = d.dynamicClient.Resource(gvr).List(ctx, metav1.ListOptions{Limit: 1})
+		return err
+	})
+	if err != nil {
+		d.log.Printf("failed counting %v: %v\n", gvr.String(), err)
+		return "?"
+	}
+	return formatCount(len(list.Items), list.GetRemainingItemCount(), list.GetContinue())
+}
+
+// formatCount formats the number of objects of a limited list.
+func formatCount(items int, remaining *int64, continueToken string) string {
+	switch {
+	case remaining != nil:
+		return strconv.FormatInt(int64(items)+*remaining, 10)
+	case continueToken != "":
+		// the API server doesn't know the number of remaining items, e.g. for lists with a field selector
+		return fmt.Sprintf(">%d", items)
+	default:
+		return strconv.Itoa(items)
+	}
+}
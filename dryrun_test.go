@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFormatCount(t *testing.T) {
+	remaining := func(n int64) *int64 { return &n }
+
+	tests := []struct {
+		name          string
+		items         int
+		remaining     *int64
+		continueToken string
+		want          string
+	}{
+		{name: "empty", want: "0"},
+		{name: "single", items: 1, want: "1"},
+		{name: "remaining", items: 1, remaining: remaining(41), continueToken: "token", want: "42"},
+		{name: "unknown remaining", items: 1, continueToken: "token", want: ">1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatCount(tt.items, tt.remaining, tt.continueToken); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	opts := testOptions()
+	opts.ignoreResources = []string{"secrets"}
+	opts.skipForbidden = true
+	opts.dryRunCount = true
+	d := newTestDumper(opts, func(resource, namespace string) bool { return resource != "namespaces" })
+
+	var out strings.Builder
+	if err := d.dryRun(context.Background(), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"GROUP VERSION RESOURCE SCOPE DUMP REASON OBJECTS",
+		"core v1 configmaps namespaced yes - 2",
+		"core v1 namespaces cluster no forbidden -",
+		"core v1 pods namespaced yes - 1",
+		"core v1 pods/log namespaced no no list verb -",
+		"core v1 secrets namespaced no filtered -",
+		"apps v1 deployments namespaced yes - 1",
+	}
+	var got []string
+	for line := range strings.Lines(out.String()) {
+		got = append(got, strings.Join(strings.Fields(line), " "))
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got output\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,678 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// options contains the settings of a dump.
+type options struct {
+	threads         uint64
+	verbosity       uint64
+	stateless       bool
+	namespaced      bool
+	clusterscoped   bool
+	retry           retryPolicy
+	failOn          string
+	report          bool
+	printReport     bool
+	pushgateway     string
+	atomicDir       bool
+	skipForbidden   bool
+	logs            logOptions
+	events          bool
+	crds            bool
+	layout          string
+	keyOrder        string
+	extractData     bool
+	chartValues     bool
+	helmReleases    bool
+	skipHelmSecrets bool
+	incremental     bool
+	checksums       bool
+	dryRunCount     bool
+	signKey         ed25519.PrivateKey // signs the checksums, nil for not signing
+
+	wantLabels       map[string]string
+	wantResources    []string
+	wantNamespaces   []string
+	wantGroups       []string
+	ignoreLabels     map[string]string
+	ignoreResources  []string
+	ignoreNamespaces []string
+	ignoreGroups     []string
+
+	filters reportFilters // the filters as given by the user, for the report
+}
+
+// dumper dumps the manifests of a single cluster.
+type dumper struct {
+	opts          options
+	server        string
+	context       string
+	namespace     string // default namespace of the context
+	clientset     kubernetes.Interface
+	dynamicClient dynamic.Interface
+	metrics       *metrics
+	writer        fileWriter
+	log           *log.Logger // prefixed with the context when dumping multiple clusters
+}
+
+// clientOptions are the settings for connecting to the API server.
+type clientOptions struct {
+	kubeconfigPath        string
+	namespace             string
+	server                string
+	token                 string
+	tokenFile             string
+	certificateAuthority  string
+	insecureSkipTLSVerify bool
+	impersonate           string
+	impersonateGroups     []string
+	proxyURL              string
+
+	qps     float32
+	burst   int
+	timeout time.Duration
+}
+
+// newDumper creates a dumper for the given context of the kubeconfig, empty for the current one.
+func newDumper(kubeContext string, client clientOptions, opts options, writer fileWriter, m *metrics, logger *log.Logger) (*dumper, error) {
+	kubeConfig, err := buildConfigFromFlags(kubeContext, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting Kubernetes config: %v", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting Kubernetes clientset: %v", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating dynamic client: %v", err)
+	}
+
+	return &dumper{
+		opts:          opts,
+		server:        kubeConfig.Host,
+		context:       resolveContext(kubeContext, client),
+		namespace:     resolveNamespace(kubeContext, client),
+		clientset:     clientset,
+		dynamicClient: dynamicClient,
+		metrics:       m,
+		writer:        writer,
+		log:           logger,
+	}, nil
+}
+
+// run dumps all manifests into outDir and returns the report and errors of this run.
+// When the context is done, no further resources are processed and the report is marked as incomplete.
+func (d *dumper) run(ctx context.Context, outDir string) (*report, *errorCollector) {
+	var (
+		writtenFiles uint64
+		errs         = &errorCollector{}
+		waitGroup    sync.WaitGroup
+		threadGuard  = make(chan struct{}, d.opts.threads)
+
+		dumpReport = &report{
+			Version:   version,
+			Commit:    commit,
+			Server:    d.server,
+			Context:   d.context,
+			Namespace: d.namespace,
+			Start:     time.Now(),
+			Filters:   d.opts.filters,
+		}
+	)
+
+	// the marker is only removed when the dump was not interrupted
+	if err := writeIncompleteMarker(d.writer, outDir); err != nil {
+		d.log.Printf("failed writing incomplete marker: %v\n", err)
+		errs.add(phaseWrite, "", "", err)
+	}
+
+	serverVersion, err := d.clientset.Discovery().ServerVersion()
+	if err != nil {
+		d.log.Printf("failed getting server version: %v\n", err)
+	} else {
+		dumpReport.ServerVersion = serverVersion.GitVersion
+	}
+
+	// resolved once when the first resource can't be listed cluster-wide
+	var (
+		fallbackOnce sync.Once
+		fallback     []string
+	)
+	fallbackNamespaces := func() []string {
+		fallbackOnce.Do(func() { fallback = d.fallbackNamespaces(ctx) })
+		return fallback
+	}
+
+	resources, err := d.discover(ctx, dumpReport, errs)
+	if err != nil {
+		// nothing was dumped, the incomplete marker is kept
+		dumpReport.finish(errs)
+		d.log.Printf("dump in %q is incomplete, the discovery failed\n", outDir)
+		return dumpReport, errs
+	}
+	d.metrics.setReady(true)
+
+	var timeline *eventTimeline
+	if d.opts.events {
+		timeline = newEventTimeline()
+	}
+
+	var helm *helmReleases
+	if d.opts.helmReleases {
+		helm = newHelmReleases()
+	}
+
+	names := newNamer(d.opts.layout)
+
+	var state *incrementalState
+	if d.opts.incremental {
+		if state, err = loadState(outDir, d.opts); err != nil {
+			// dump all objects, the state is replaced
+			d.log.Printf("%v\n", err)
+			errs.add(phaseWrite, "state", "", err)
+		}
+	}
+
+	var values *chartValues
+	if d.opts.layout == layoutHelm {
+		values = newChartValues()
+	}
+
+	var crds *crdIndex
+	if d.listsCRDs() {
+		if crds, err = d.listCRDs(ctx); err != nil {
+			d.log.Printf("%v\n", err)
+			errs.add(phaseList, crdsGVR.String(), "", err)
+		} else {
+			var skipped []apiResource
+			var warnings []string
+			resources, skipped, warnings = crds.storageVersions(resources)
+			for _, res := range skipped {
+				dumpReport.addSkipped(res.gvr, skipReasonStorageVersion)
+			}
+			for _, warning := range warnings {
+				d.log.Printf("warning: %v\n", warning)
+			}
+		}
+	}
+
+	for _, res := range resources {
+		waitGroup.Add(1)
+		select {
+		case threadGuard <- struct{}{}: // would block if guard channel is already filled
+		case <-ctx.Done():
+			waitGroup.Done() // the resource won't be processed
+			continue
+		}
+
+		go func(res apiResource) {
+			defer func() {
+				waitGroup.Done()
+				<-threadGuard
+			}()
+
+			gvr := res.gvr
+
+			var skipClusterWide bool
+			if d.opts.skipForbidden {
+				allowed, err := d.canList(ctx, gvr, "")
+				if err != nil {
+					// list anyway, the list error tells more
+					d.log.Printf("%v\n", err)
+				} else if !allowed && !res.namespaced {
+					dumpReport.addSkipped(gvr, skipReasonForbidden)
+					return
+				} else if !allowed {
+					skipClusterWide = true // list the namespaces instead
+				}
+			}
+
+			if d.opts.verbosity > 1 {
+				fmt.Fprintf(infoOutput, "%sprocessing group=%v resource=%v\n", d.log.Prefix(), gvr.Group, gvr.Resource)
+			}
+
+			listStart := time.Now()
+			unstrList, listedNamespaces, err := d.list(ctx, res, skipClusterWide, fallbackNamespaces, errs)
+			if ctx.Err() != nil {
+				return // interrupted, not an error of the resource
+			}
+			if err != nil {
+				d.log.Printf("failed listing %v: %v\n", gvr.String(), err)
+				errs.add(phaseList, gvr.String(), "", err)
+				return
+			}
+			if unstrList == nil {
+				// none of the namespaces could be listed, the errors were collected by list
+				if d.opts.skipForbidden {
+					dumpReport.addSkipped(gvr, skipReasonForbidden)
+				}
+				return
+			}
+
+			resReport := reportResource{
+				Group:        gvr.Group,
+				Version:      gvr.Version,
+				Resource:     gvr.Resource,
+				Listed:       len(unstrList.Items),
+				ListDuration: time.Since(listStart).Seconds(),
+				Namespaces:   listedNamespaces,
+			}
+			defer func() { dumpReport.addResource(resReport) }()
+
+			// Use a combination of resource and group name as it might not be unique otherwise.
+			// Example content of the variables:
+			//		resource: "pod"		group: ""
+			//		resource: "pod"		group: "metrics.k8s.io"
+			resourceAndGroup := strings.TrimSuffix(fmt.Sprintf("%s.%s", gvr.Resource, gvr.Group), ".")
+
+			if state != nil {
+				state.addListed(resourceAndGroup, listedNamespaces)
+			}
+
+			for _, item := range unstrList.Items {
+				// finish the current write, but don't start a new one
+				if ctx.Err() != nil {
+					return
+				}
+
+				if state != nil {
+					state.see(item)
+				}
+
+				if skipItem(item, d.opts.namespaced, d.opts.clusterscoped, d.opts.wantNamespaces, d.opts.ignoreNamespaces) {
+					continue
+				}
+
+				if skipLabels(item.GetLabels(), d.opts.wantLabels, d.opts.ignoreLabels) {
+					continue
+				}
+
+				manifestPath := names.objectPath(outDir, resourceAndGroup, item.GetNamespace(), item.GetName())
+
+				if d.opts.verbosity > 2 {
+					fmt.Fprintf(infoOutput, "%sprocessing manifest group=%v version=%v resource=%v namespace=%v name=%q\n", d.log.Prefix(), gvr.Group, gvr.Version, gvr.Resource, item.GetNamespace(), item.GetName())
+				}
+
+				// get the containers before the status is removed when writing
+				var containerLogs []containerLog
+				if d.opts.logs.enabled && gvr == podsGVR {
+					if containerLogs, err = podLogs(item, d.opts.logs.previous); err != nil {
+						d.log.Printf("failed getting containers of %v/%v: %v\n", item.GetNamespace(), item.GetName(), err)
+						errs.add(phaseLogs, gvr.String(), fmt.Sprintf("%v/%v", item.GetNamespace(), item.GetName()), err)
+					}
+				}
+
+				if timeline != nil && isEventsResource(gvr) {
+					if err := timeline.addEvent(item, gvr); err != nil {
+						d.log.Printf("failed adding event %v/%v to the timeline: %v\n", item.GetNamespace(), item.GetName(), err)
+						errs.add(phaseWrite, gvr.String(), fmt.Sprintf("%v/%v", item.GetNamespace(), item.GetName()), err)
+					}
+				}
+
+				if helm != nil && isHelmRelease(gvr, item) {
+					release, err := decodeHelmRelease(item)
+					if err == nil {
+						err = helm.writeRelease(d.writer, outDir, release)
+					}
+					if err != nil {
+						d.log.Printf("failed writing helm release of %v/%v: %v\n", item.GetNamespace(), item.GetName(), err)
+						errs.add(phaseWrite, gvr.String(), fmt.Sprintf("%v/%v", item.GetNamespace(), item.GetName()), err)
+					} else if d.opts.skipHelmSecrets {
+						continue
+					}
+				}
+
+				var (
+					stateObj  objectState
+					unchanged bool
+				)
+				if state != nil {
+					if stateObj, unchanged, err = state.compare(resourceAndGroup, manifestPath, item, d.opts.stateless); err != nil {
+						// written regardless, but not added to the state
+						d.log.Printf("failed comparing %v/%v with the previous dump: %v\n", item.GetNamespace(), item.GetName(), err)
+						errs.add(phaseWrite, gvr.String(), fmt.Sprintf("%v/%v", item.GetNamespace(), item.GetName()), err)
+					}
+				}
+
+				// lifted for unchanged objects as well, the values are written for all objects
+				if values != nil && d.opts.chartValues {
+					values.lift(resourceAndGroup, item)
+				}
+
+				if !unchanged {
+					var extracted int
+					if d.opts.extractData && isDataResource(gvr) {
+						if extracted, err = extractData(d.writer, manifestPath, item); err != nil {
+							d.log.Printf("failed extracting data of %v/%v: %v\n", item.GetNamespace(), item.GetName(), err)
+							errs.add(phaseWrite, gvr.String(), fmt.Sprintf("%v/%v", item.GetNamespace(), item.GetName()), err)
+							continue
+						}
+					}
+
+					written, err := writeYAML(d.writer, manifestPath, item, d.opts)
+					if err != nil {
+						d.log.Printf("failed writing %v/%v: %v\n", item.GetNamespace(), item.GetName(), err)
+						errs.add(phaseWrite, gvr.String(), fmt.Sprintf("%v/%v", item.GetNamespace(), item.GetName()), err)
+						continue
+					}
+					resReport.Bytes += written + extracted
+				} else {
+					resReport.Unchanged++
+				}
+				atomic.AddUint64(&writtenFiles, 1)
+				resReport.Objects++
+
+				if state != nil && stateObj.Hash != "" {
+					state.add(manifestPath, stateObj)
+				}
+
+				if crds != nil && crds.isCustom(gvr) {
+					crds.addInstance(gvr, item.GetNamespace(), item.GetName())
+				}
+				if timeline != nil {
+					timeline.addObject(gvr.Group, item.GetKind(), item.GetNamespace(), item.GetName(), manifestPath)
+				}
+
+				// the logs are collected by separate goroutines, which don't block
+				// the thread of this resource while waiting for a free thread
+				for _, containerLog := range containerLogs {
+					waitGroup.Add(1)
+					go func(namespace, pod string) {
+						defer waitGroup.Done()
+
+						select {
+						case threadGuard <- struct{}{}:
+							defer func() { <-threadGuard }()
+						case <-ctx.Done():
+							return
+						}
+
+						written, err := d.writeContainerLog(ctx, manifestPath, namespace, pod, containerLog)
+						if ctx.Err() != nil {
+							return
+						}
+						if err != nil {
+							d.log.Printf("failed collecting log of %v/%v container %v: %v\n", namespace, pod, containerLog.container, err)
+							errs.add(phaseLogs, gvr.String(), fmt.Sprintf("%v/%v/%v", namespace, pod, containerLog.container), err)
+							return
+						}
+						dumpReport.addLog(written)
+					}(item.GetNamespace(), item.GetName())
+				}
+			}
+		}(res)
+	}
+
+	waitGroup.Wait()
+
+	if timeline != nil {
+		if err := timeline.write(d.writer, outDir); err != nil {
+			d.log.Printf("failed writing events timeline: %v\n", err)
+			errs.add(phaseWrite, "events", "", err)
+		}
+	}
+	if err := names.writeIndex(d.writer, outDir); err != nil {
+		d.log.Printf("failed writing names index: %v\n", err)
+		errs.add(phaseWrite, "names", "", err)
+	}
+	if d.opts.layout == layoutKustomize {
+		if err := writeKustomizations(d.writer, outDir); err != nil {
+			d.log.Printf("failed writing kustomizations: %v\n", err)
+			errs.add(phaseWrite, "kustomize", "", err)
+		}
+	}
+	if values != nil {
+		if err := writeCharts(d.writer, outDir, values); err != nil {
+			d.log.Printf("failed writing charts: %v\n", err)
+			errs.add(phaseWrite, "helm", "", err)
+		}
+	}
+	if helm != nil {
+		if err := helm.writeHistories(d.writer, outDir); err != nil {
+			d.log.Printf("failed writing helm release histories: %v\n", err)
+			errs.add(phaseWrite, "helm", "", err)
+		}
+	}
+	if crds != nil {
+		if err := crds.write(d.writer, outDir, d.opts, names); err != nil {
+			d.log.Printf("%v\n", err)
+			errs.add(phaseWrite, crdsGVR.String(), "", err)
+		}
+	}
+	// an interrupted dump didn't see all objects, the next one compares with the previous state
+	if state != nil && ctx.Err() == nil {
+		removed, err := state.removeDeleted()
+		dumpReport.Removed = uint64(removed)
+		if err != nil {
+			d.log.Printf("%v\n", err)
+			errs.add(phaseWrite, "state", "", err)
+		}
+		if err := state.write(d.writer); err != nil {
+			d.log.Printf("failed writing state: %v\n", err)
+			errs.add(phaseWrite, "state", "", err)
+		}
+	}
+
+	d.complete(ctx, outDir, dumpReport, errs)
+
+	if d.opts.verbosity > 0 {
+		fmt.Fprintf(infoOutput, "%sloaded %d manifests in %v\n", d.log.Prefix(), writtenFiles, dumpReport.End.Sub(dumpReport.Start).Round(1*time.Millisecond))
+	}
+
+	return dumpReport, errs
+}
+
+// dumpTo runs a dump into outDir and returns its report and whether it failed.
+// With -atomic-dir, the dump is built in a staging directory which replaces outDir only on success.
+func (d *dumper) dumpTo(ctx context.Context, outDir string) (*report, bool) {
+	runDir := outDir
+	if d.opts.atomicDir {
+		runDir = stagingDir(outDir)
+
+		// leftover of a failed run
+		if err := os.RemoveAll(runDir); err != nil {
+			d.log.Printf("failed removing staging dir %q: %v\n", runDir, err)
+		}
+	}
+
+	dumpReport, errs := d.run(ctx, runDir)
+	failed := d.finish(runDir, dumpReport, errs)
+
+	if !d.opts.atomicDir {
+		return dumpReport, failed
+	}
+
+	if failed {
+		d.log.Printf("dump failed, keeping %q and the failed dump in %q\n", outDir, runDir)
+		return dumpReport, failed
+	}
+
+	if err := swapDir(runDir, outDir); err != nil {
+		d.log.Printf("failed replacing %q: %v\n", outDir, err)
+		return dumpReport, true
+	}
+
+	return dumpReport, failed
+}
+
+// complete finishes the report and removes the incomplete marker, unless the context is done.
+func (d *dumper) complete(ctx context.Context, outDir string, dumpReport *report, errs *errorCollector) {
+	dumpReport.Complete = ctx.Err() == nil
+	dumpReport.finish(errs)
+
+	if !dumpReport.Complete {
+		d.log.Printf("dump in %q was interrupted and is incomplete\n", outDir)
+		return
+	}
+
+	if err := removeIncompleteMarker(outDir); err != nil {
+		d.log.Printf("failed removing incomplete marker: %v\n", err)
+		errs.add(phaseWrite, "", "", err)
+	}
+}
+
+// finish writes the report, updates the metrics and prints the errors of a run.
+// It returns whether the run failed according to the -fail-on flag or was interrupted.
+func (d *dumper) finish(outDir string, dumpReport *report, errs *errorCollector) bool {
+	if d.opts.report {
+		if err := writeReport(d.writer, outDir, dumpReport); err != nil {
+			// not included in the report, but in the summary and the exit code
+			d.log.Printf("failed writing report: %v\n", err)
+			errs.add(phaseWrite, reportFilename, "", err)
+		}
+	}
+	if d.opts.printReport {
+		reportBytes, err := dumpReport.marshal()
+		if err != nil {
+			d.log.Printf("failed marshalling report: %v\n", err)
+		} else {
+			fmt.Println(string(reportBytes))
+		}
+	}
+	// the report is included in the checksums
+	if d.opts.checksums {
+		if err := writeChecksums(d.writer, outDir, d.opts.signKey); err != nil {
+			d.log.Printf("failed writing checksums: %v\n", err)
+			errs.add(phaseWrite, checksumsFilename, "", err)
+		}
+	}
+
+	failed := errs.failed(d.opts.failOn) || !dumpReport.Complete
+	d.metrics.observe(dumpReport, errs, !failed)
+	if d.opts.pushgateway != "" {
+		if err := pushMetrics(d.opts.pushgateway, d.metrics); err != nil {
+			d.log.Printf("failed pushing metrics to %q: %v\n", d.opts.pushgateway, err)
+		}
+	}
+
+	// write the summary at once, it would be interleaved with the ones of other clusters otherwise
+	var summary, prefixed strings.Builder
+	errs.printSummary(&summary)
+	for line := range strings.Lines(summary.String()) {
+		prefixed.WriteString(d.log.Prefix() + line)
+	}
+	fmt.Fprint(os.Stderr, prefixed.String())
+
+	return failed
+}
+
+// apiResource is a listable resource selected by the filters.
+type apiResource struct {
+	gvr        schema.GroupVersionResource
+	namespaced bool
+}
+
+// discover returns the resources selected by the group and resource filters and adds the skipped ones to the report.
+// Errors of single group versions are collected, an error is only returned when the groups can't be discovered.
+func (d *dumper) discover(ctx context.Context, dumpReport *report, errs *errorCollector) ([]apiResource, error) {
+	planned, err := d.discoverAll(ctx, errs)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []apiResource
+	for _, res := range planned {
+		if res.reason != "" {
+			dumpReport.addSkipped(res.gvr, res.reason)
+			continue
+		}
+		selected = append(selected, res.apiResource)
+	}
+	return selected, nil
+}
+
+// plannedResource is a discovered resource with the reason why it's skipped, empty when it's selected.
+// Skipped groups have neither a version nor a resource.
+type plannedResource struct {
+	apiResource
+	reason string
+}
+
+// discoverAll returns all discovered resources in the order of the discovery, with the reason when they are skipped.
+func (d *dumper) discoverAll(ctx context.Context, errs *errorCollector) ([]plannedResource, error) {
+	var groups *metav1.APIGroupList
+	err := withRetry(ctx, d.opts.retry, func() (err error) {
+		groups, err = d.clientset.Discovery().ServerGroups()
+		return err
+	})
+	if err != nil {
+		d.log.Printf("failed getting server groups: %v\n", err)
+		errs.add(phaseDiscovery, "", "", err)
+		return nil, err
+	}
+
+	var planned []plannedResource
+	for _, group := range groups.Groups {
+		if skipGroup(group, d.opts.wantGroups, d.opts.ignoreGroups) {
+			planned = append(planned, plannedResource{
+				apiResource: apiResource{gvr: schema.GroupVersionResource{Group: group.Name}},
+				reason:      skipReasonFiltered,
+			})
+			continue
+		}
+
+		for _, version := range group.Versions {
+			if ctx.Err() != nil {
+				return planned, nil
+			}
+
+			var resources *metav1.APIResourceList
+			err := withRetry(ctx, d.opts.retry, func() (err error) {
+				resources, err = d.clientset.Discovery().ServerResourcesForGroupVersion(version.GroupVersion)
+				return err
+			})
+			if err != nil {
+				d.log.Printf("failed getting resources for %q: %v\n", version.GroupVersion, err)
+				errs.add(phaseDiscovery, version.GroupVersion, "", err)
+				continue
+			}
+
+			for _, res := range resources.APIResources {
+				gvr := schema.GroupVersionResource{
+					Group:    group.Name,
+					Version:  version.Version,
+					Resource: res.Name,
+				}
+
+				reason := skipResourceReason(res, d.opts.wantResources, d.opts.ignoreResources)
+				if reason == "" && !d.listsScope(res.Namespaced) {
+					reason = skipReasonFiltered
+				}
+				planned = append(planned, plannedResource{
+					apiResource: apiResource{gvr: gvr, namespaced: res.Namespaced},
+					reason:      reason,
+				})
+			}
+		}
+	}
+
+	if d.opts.events {
+		_, duplicates := dedupeEvents(selectedResources(planned))
+		for i, res := range planned {
+			if res.reason == "" && slices.Contains(duplicates, res.apiResource) {
+				planned[i].reason = skipReasonDuplicate
+			}
+		}
+	}
+
+	return planned, nil
+}
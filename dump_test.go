@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// testResources are served by the fake API server of newTestDumper.
+var testResources = []*metav1.APIResourceList{
+	{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{
+			{Name: "namespaces", Kind: "Namespace", Verbs: []string{"list"}},
+			{Name: "pods", Kind: "Pod", Namespaced: true, Verbs: []string{"list"}},
+			{Name: "pods/log", Kind: "Pod", Namespaced: true, Verbs: []string{"get"}},
+			{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: []string{"list"}},
+			{Name: "secrets", Kind: "Secret", Namespaced: true, Verbs: []string{"list"}},
+		},
+	},
+	{
+		GroupVersion: "apps/v1",
+		APIResources: []metav1.APIResource{
+			{Name: "deployments", Kind: "Deployment", Namespaced: true, Verbs: []string{"list"}},
+		},
+	},
+}
+
+func testObject(apiVersion, kind, namespace, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata": map[string]any{
+			"name": name,
+			"uid":  "uid-" + kind + "-" + namespace + "-" + name,
+		},
+	}}
+	obj.SetNamespace(namespace)
+	return obj
+}
+
+// testObjects are the objects of the fake API server of newTestDumper.
+func testObjects() []runtime.Object {
+	return []runtime.Object{
+		testObject("v1", "Namespace", "", "team-a"),
+		testObject("v1", "Namespace", "", "team-b"),
+		testObject("v1", "Pod", "team-a", "web"),
+		testObject("v1", "ConfigMap", "team-a", "app"),
+		testObject("v1", "ConfigMap", "team-b", "other"),
+		testObject("v1", "Secret", "team-a", "creds"),
+		testObject("apps/v1", "Deployment", "team-a", "web"),
+	}
+}
+
+// newTestDumper returns a dumper with a fake API server serving the test resources and objects.
+// allowed tells whether the user may list the resource in the namespace, empty for cluster-wide.
+// The default namespace of the context is team-a.
+func newTestDumper(opts options, allowed func(resource, namespace string) bool) *dumper {
+	clientset := fake.NewClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}},
+	)
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).Resources = testResources
+
+	clientset.PrependReactor("list", "namespaces", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if !allowed("namespaces", "") {
+			return true, nil, apierrors.NewForbidden(corev1.Resource("namespaces"), "", errors.New("test"))
+		}
+		return false, nil, nil
+	})
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		review := action.(clienttesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		attributes := review.Spec.ResourceAttributes
+		review.Status.Allowed = attributes.Verb == "list" && allowed(attributes.Resource, attributes.Namespace)
+		return true, review, nil
+	})
+	clientset.PrependReactor("create", "selfsubjectrulesreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		review := action.(clienttesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectRulesReview)
+		for _, list := range testResources {
+			gv, _ := schema.ParseGroupVersion(list.GroupVersion)
+			for _, res := range list.APIResources {
+				if res.Namespaced && allowed(res.Name, review.Spec.Namespace) {
+					review.Status.ResourceRules = append(review.Status.ResourceRules, authorizationv1.ResourceRule{
+						Verbs:     []string{"list"},
+						APIGroups: []string{gv.Group},
+						Resources: []string{res.Name},
+					})
+				}
+			}
+		}
+		return true, review, nil
+	})
+
+	listKinds := make(map[schema.GroupVersionResource]string)
+	for _, list := range testResources {
+		gv, _ := schema.ParseGroupVersion(list.GroupVersion)
+		for _, res := range list.APIResources {
+			listKinds[gv.WithResource(res.Name)] = res.Kind + "List"
+		}
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, testObjects()...)
+	dynamicClient.PrependReactor("list", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		gvr := action.GetResource()
+		if !allowed(gvr.Resource, action.GetNamespace()) {
+			return true, nil, apierrors.NewForbidden(gvr.GroupResource(), "", errors.New("test"))
+		}
+		return false, nil, nil
+	})
+
+	return &dumper{
+		opts:          opts,
+		server:        "https://fake",
+		context:       "fake",
+		namespace:     "team-a",
+		clientset:     clientset,
+		dynamicClient: dynamicClient,
+		metrics:       newMetrics(),
+		writer:        fileWriter{fileMode: 0o600, dirMode: 0o700, uid: -1, gid: -1},
+		log:           log.New(io.Discard, "", 0),
+	}
+}
+
+func testOptions() options {
+	return options{
+		threads:       2,
+		stateless:     true,
+		namespaced:    true,
+		clusterscoped: true,
+		failOn:        failOnAny,
+		report:        true,
+		layout:        layoutDefault,
+		keyOrder:      keyOrderAlphabetical,
+	}
+}
+
+func allowAll(resource, namespace string) bool {
+	return true
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name      string
+		modify    func(opts *options)
+		allowed   func(resource, namespace string) bool
+		wantFiles []string
+	}{
+		{
+			name:    "cluster",
+			allowed: allowAll,
+			wantFiles: []string{
+				"clusterscoped/namespaces/team-a.yaml",
+				"clusterscoped/namespaces/team-b.yaml",
+				"namespaced/team-a/pods/web.yaml",
+				"namespaced/team-a/configmaps/app.yaml",
+				"namespaced/team-b/configmaps/other.yaml",
+				"namespaced/team-a/secrets/creds.yaml",
+				"namespaced/team-a/deployments.apps/web.yaml",
+			},
+		},
+		{
+			// a tenant can only list its own namespace, cluster-scoped resources and the CRDs aren't listed
+			name: "tenant",
+			modify: func(opts *options) {
+				opts.wantNamespaces = []string{"team-a"}
+				opts.clusterscoped = false
+				opts.crds = true
+			},
+			allowed: func(resource, namespace string) bool { return namespace == "team-a" },
+			wantFiles: []string{
+				"namespaced/team-a/pods/web.yaml",
+				"namespaced/team-a/configmaps/app.yaml",
+				"namespaced/team-a/secrets/creds.yaml",
+				"namespaced/team-a/deployments.apps/web.yaml",
+			},
+		},
+		{
+			name: "cluster-scoped only",
+			modify: func(opts *options) {
+				opts.namespaced = false
+			},
+			allowed: func(resource, namespace string) bool { return resource == "namespaces" },
+			wantFiles: []string{
+				"clusterscoped/namespaces/team-a.yaml",
+				"clusterscoped/namespaces/team-b.yaml",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outDir := t.TempDir()
+			opts := testOptions()
+			if tt.modify != nil {
+				tt.modify(&opts)
+			}
+			d := newTestDumper(opts, tt.allowed)
+
+			dumpReport, errs := d.run(context.Background(), outDir)
+			if got := errs.all(); len(got) != 0 {
+				t.Fatalf("got errors %v", got)
+			}
+			if !dumpReport.Complete {
+				t.Error("dump isn't complete")
+			}
+			if dumpReport.Objects != uint64(len(tt.wantFiles)) {
+				t.Errorf("got %v objects, want %v", dumpReport.Objects, len(tt.wantFiles))
+			}
+
+			for _, name := range tt.wantFiles {
+				if _, err := os.Stat(filepath.Join(outDir, name)); err != nil {
+					t.Errorf("manifest %v wasn't written: %v", name, err)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(outDir, incompleteMarker)); !os.IsNotExist(err) {
+				t.Errorf("incomplete marker wasn't removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestDumpToAtomicDir(t *testing.T) {
+	outDir := filepath.Join(t.TempDir(), "dump")
+	opts := testOptions()
+	opts.atomicDir = true
+
+	if _, failed := newTestDumper(opts, allowAll).dumpTo(context.Background(), outDir); failed {
+		t.Fatal("first dump failed")
+	}
+	if _, err := os.Stat(filepath.Join(outDir, reportFilename)); err != nil {
+		t.Errorf("report wasn't written into the output dir: %v", err)
+	}
+
+	// listing secrets fails, the previous dump is kept
+	denySecrets := func(resource, namespace string) bool { return resource != "secrets" }
+	if _, failed := newTestDumper(opts, denySecrets).dumpTo(context.Background(), outDir); !failed {
+		t.Fatal("second dump didn't fail")
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "namespaced/team-a/secrets/creds.yaml")); err != nil {
+		t.Errorf("previous dump wasn't kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(stagingDir(outDir), reportFilename)); err != nil {
+		t.Errorf("failed dump wasn't kept in the staging dir: %v", err)
+	}
+
+	// the next successful dump replaces the output dir
+	if _, failed := newTestDumper(opts, allowAll).dumpTo(context.Background(), outDir); failed {
+		t.Fatal("third dump failed")
+	}
+	if _, err := os.Stat(stagingDir(outDir)); !os.IsNotExist(err) {
+		t.Errorf("staging dir still exists: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir+previousSuffix, reportFilename)); err != nil {
+		t.Errorf("replaced dump wasn't kept: %v", err)
+	}
+}
+
+func TestRunDiscoveryFailure(t *testing.T) {
+	outDir := t.TempDir()
+	d := newTestDumper(testOptions(), allowAll)
+	d.clientset.(*fake.Clientset).PrependReactor("get", "group", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("test")
+	})
+
+	dumpReport, errs := d.run(context.Background(), outDir)
+	if len(errs.all()) != 1 {
+		t.Errorf("got errors %v, want the discovery error", errs.all())
+	}
+	if dumpReport.Complete {
+		t.Error("dump is complete")
+	}
+	if _, err := os.Stat(filepath.Join(outDir, incompleteMarker)); err != nil {
+		t.Errorf("incomplete marker was removed: %v", err)
+	}
+}
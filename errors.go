@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+type dumpPhase string
+
+const (
+	phaseDiscovery dumpPhase = "discovery"
+	phaseList      dumpPhase = "list"
+	phaseWrite     dumpPhase = "write"
+	phaseLogs      dumpPhase = "logs"
+)
+
+type errorCategory string
+
+const (
+	categoryForbidden errorCategory = "forbidden"
+	categoryNotFound  errorCategory = "not found"
+	categoryTimeout   errorCategory = "timeout"
+	categoryWrite     errorCategory = "write"
+	categoryOther     errorCategory = "other"
+)
+
+// order in which the categories are printed in the summary
+var errorCategories = []errorCategory{categoryForbidden, categoryNotFound, categoryTimeout, categoryWrite, categoryOther}
+
+// values of the -fail-on flag
+const (
+	failOnNone  = "none"
+	failOnWrite = "write" // errors of the write phase
+	failOnList  = "list"  // errors of the discovery, list and logs phases, not of the write phase
+	failOnAny   = "any"
+)
+
+var failOnValues = []string{failOnNone, failOnWrite, failOnList, failOnAny}
+
+type dumpError struct {
+	Phase    dumpPhase
+	Category errorCategory
+	Resource string // group version (resource) the error belongs to
+	Object   string // namespace/name of the object, empty if not object related
+	Err      error
+}
+
+func (e dumpError) String() string {
+	if e.Object != "" {
+		return fmt.Sprintf("%v %v %v: %v", e.Phase, e.Resource, e.Object, e.Err)
+	}
+	return fmt.Sprintf("%v %v: %v", e.Phase, e.Resource, e.Err)
+}
+
+// errorCollector gathers the errors of a dump and is safe for concurrent use.
+type errorCollector struct {
+	mu   sync.Mutex
+	errs []dumpError
+}
+
+func (c *errorCollector) add(phase dumpPhase, resource, object string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.errs = append(c.errs, dumpError{
+		Phase:    phase,
+		Category: categorize(phase, err),
+		Resource: resource,
+		Object:   object,
+		Err:      err,
+	})
+}
+
+func (c *errorCollector) all() []dumpError {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.Clone(c.errs)
+}
+
+// failed reports whether the collected errors should result in a failed run, based on the -fail-on flag.
+func (c *errorCollector) failed(failOn string) bool {
+	for _, e := range c.all() {
+		switch failOn {
+		case failOnAny:
+			return true
+		case failOnWrite:
+			if e.Phase == phaseWrite {
+				return true
+			}
+		case failOnList:
+			if e.Phase == phaseDiscovery || e.Phase == phaseList || e.Phase == phaseLogs {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *errorCollector) printSummary(w io.Writer) {
+	errs := c.all()
+	if len(errs) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "%d errors occurred:\n", len(errs))
+	for _, category := range errorCategories {
+		var inCategory []dumpError
+		for _, e := range errs {
+			if e.Category == category {
+				inCategory = append(inCategory, e)
+			}
+		}
+		if len(inCategory) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(w, "  %v (%d):\n", category, len(inCategory))
+		for _, e := range inCategory {
+			fmt.Fprintf(w, "    %v\n", e)
+		}
+	}
+}
+
+func categorize(phase dumpPhase, err error) errorCategory {
+	switch {
+	case phase == phaseWrite:
+		return categoryWrite
+	case apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err):
+		return categoryForbidden
+	case apierrors.IsNotFound(err):
+		return categoryNotFound
+	case apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err) || utilnet.IsTimeout(err) || errors.Is(err, context.DeadlineExceeded):
+		return categoryTimeout
+	default:
+		return categoryOther
+	}
+}
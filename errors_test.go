@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestCategorize(t *testing.T) {
+	gr := schema.GroupResource{Resource: "pods"}
+
+	tests := []struct {
+		name  string
+		phase dumpPhase
+		err   error
+		want  errorCategory
+	}{
+		{
+			name:  "forbidden",
+			phase: phaseList,
+			err:   apierrors.NewForbidden(gr, "", errors.New("no")),
+			want:  categoryForbidden,
+		},
+		{
+			name:  "unauthorized",
+			phase: phaseList,
+			err:   apierrors.NewUnauthorized("no"),
+			want:  categoryForbidden,
+		},
+		{
+			name:  "not found",
+			phase: phaseDiscovery,
+			err:   apierrors.NewNotFound(gr, ""),
+			want:  categoryNotFound,
+		},
+		{
+			name:  "server timeout",
+			phase: phaseList,
+			err:   apierrors.NewServerTimeout(gr, "list", 1),
+			want:  categoryTimeout,
+		},
+		{
+			name:  "deadline exceeded",
+			phase: phaseList,
+			err:   context.DeadlineExceeded,
+			want:  categoryTimeout,
+		},
+		{
+			name:  "write",
+			phase: phaseWrite,
+			err:   errors.New("disk full"),
+			want:  categoryWrite,
+		},
+		{
+			name:  "other",
+			phase: phaseList,
+			err:   errors.New("boom"),
+			want:  categoryOther,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := categorize(tt.phase, tt.err); got != tt.want {
+				t.Errorf("categorize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorCollectorFailed(t *testing.T) {
+	tests := []struct {
+		name   string
+		phases []dumpPhase
+		failOn string
+		want   bool
+	}{
+		{
+			name:   "no errors",
+			failOn: failOnAny,
+			want:   false,
+		},
+		{
+			name:   "none",
+			phases: []dumpPhase{phaseList, phaseWrite},
+			failOn: failOnNone,
+			want:   false,
+		},
+		{
+			name:   "any",
+			phases: []dumpPhase{phaseDiscovery},
+			failOn: failOnAny,
+			want:   true,
+		},
+		{
+			name:   "write with write error",
+			phases: []dumpPhase{phaseWrite},
+			failOn: failOnWrite,
+			want:   true,
+		},
+		{
+			name:   "write with list error",
+			phases: []dumpPhase{phaseList},
+			failOn: failOnWrite,
+			want:   false,
+		},
+		{
+			name:   "list with discovery error",
+			phases: []dumpPhase{phaseDiscovery},
+			failOn: failOnList,
+			want:   true,
+		},
+		{
+			name:   "list with write error",
+			phases: []dumpPhase{phaseWrite},
+			failOn: failOnList,
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c errorCollector
+			for _, phase := range tt.phases {
+				c.add(phase, "v1", "", errors.New("boom"))
+			}
+			if got := c.failed(tt.failOn); got != tt.want {
+				t.Errorf("failed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
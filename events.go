@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	eventsFilename   = "events.txt"
+	eventsFileSuffix = ".events.txt"
+)
+
+// event is an event of the core or the events.k8s.io API.
+type event struct {
+	namespace string
+	name      string
+	time      time.Time
+	eventType string
+	reason    string
+	message   string
+	count     int32
+	object    eventObject
+}
+
+// eventObject is the object an event is about.
+type eventObject struct {
+	group     string
+	kind      string
+	namespace string
+	name      string
+}
+
+// eventTimeline collects the events and the dumped objects for writing the timelines.
+type eventTimeline struct {
+	mu      sync.Mutex
+	events  map[string]event       // by namespace/name, the same event is served by the core and the events.k8s.io API
+	objects map[eventObject]string // path of the dumped objects without extension
+}
+
+func newEventTimeline() *eventTimeline {
+	return &eventTimeline{
+		events:  make(map[string]event),
+		objects: make(map[eventObject]string),
+	}
+}
+
+// isEventsResource reports whether the resource contains events of the core or the events.k8s.io API.
+func isEventsResource(gvr schema.GroupVersionResource) bool {
+	return gvr.Resource == "events" && (gvr.Group == "" || gvr.Group == eventsv1.GroupName)
+}
+
+// dedupeEvents removes the core events when the events.k8s.io events are served as well,
+// both APIs serve the same events. The removed resources are returned separately.
+func dedupeEvents(resources []apiResource) (kept, duplicates []apiResource) {
+	var newAPI bool
+	for _, res := range resources {
+		newAPI = newAPI || (isEventsResource(res.gvr) && res.gvr.Group == eventsv1.GroupName)
+	}
+
+	for _, res := range resources {
+		if newAPI && isEventsResource(res.gvr) && res.gvr.Group == "" {
+			duplicates = append(duplicates, res)
+			continue
+		}
+		kept = append(kept, res)
+	}
+	return kept, duplicates
+}
+
+// addEvent adds an event of the core or the events.k8s.io API.
+func (t *eventTimeline) addEvent(item unstructured.Unstructured, gvr schema.GroupVersionResource) error {
+	var e event
+	if gvr.Group == eventsv1.GroupName {
+		var ev eventsv1.Event
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &ev); err != nil {
+			return fmt.Errorf("failed converting event: %v", err)
+		}
+		e = event{
+			eventType: ev.Type,
+			reason:    ev.Reason,
+			message:   ev.Note,
+			count:     ev.DeprecatedCount,
+			object:    newEventObject(ev.Regarding),
+			time:      firstTime(ev.DeprecatedLastTimestamp.Time, ev.EventTime.Time, ev.DeprecatedFirstTimestamp.Time, ev.CreationTimestamp.Time),
+		}
+		if ev.Series != nil {
+			e.count = ev.Series.Count
+			e.time = firstTime(ev.Series.LastObservedTime.Time, e.time)
+		}
+	} else {
+		var ev corev1.Event
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &ev); err != nil {
+			return fmt.Errorf("failed converting event: %v", err)
+		}
+		e = event{
+			eventType: ev.Type,
+			reason:    ev.Reason,
+			message:   ev.Message,
+			count:     ev.Count,
+			object:    newEventObject(ev.InvolvedObject),
+			time:      firstTime(ev.LastTimestamp.Time, ev.EventTime.Time, ev.FirstTimestamp.Time, ev.CreationTimestamp.Time),
+		}
+		if ev.Series != nil {
+			e.count = ev.Series.Count
+			e.time = firstTime(ev.Series.LastObservedTime.Time, e.time)
+		}
+	}
+	e.namespace = item.GetNamespace()
+	e.name = item.GetName()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events[e.namespace+"/"+e.name] = e
+	return nil
+}
+
+// addObject records a dumped object, its events are written next to it.
+func (t *eventTimeline) addObject(group, kind, namespace, name, path string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.objects[eventObject{group: group, kind: kind, namespace: namespace, name: name}] = path
+}
+
+func newEventObject(ref corev1.ObjectReference) eventObject {
+	gv, _ := schema.ParseGroupVersion(ref.APIVersion) // the group is empty for invalid versions
+	return eventObject{group: gv.Group, kind: ref.Kind, namespace: ref.Namespace, name: ref.Name}
+}
+
+// firstTime returns the first of the times which is set.
+func firstTime(times ...time.Time) time.Time {
+	for _, t := range times {
+		if !t.IsZero() {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// write writes the timeline of each namespace and of each dumped object with events.
+// Events without a namespace are written to the cluster-scoped directory.
+func (t *eventTimeline) write(w fileWriter, outDir string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var (
+		byNamespace = make(map[string][]event)
+		byObject    = make(map[string][]event)
+	)
+	for _, e := range t.events {
+		byNamespace[e.namespace] = append(byNamespace[e.namespace], e)
+		if path, ok := t.objects[e.object]; ok {
+			byObject[path] = append(byObject[path], e)
+		}
+	}
+
+	for namespace, events := range byNamespace {
+		dir := filepath.Join(outDir, "clusterscoped")
+		if namespace != "" {
+			dir = filepath.Join(outDir, "namespaced", namespace)
+		}
+		if err := w.writeFile(filepath.Join(dir, eventsFilename), formatEvents(events)); err != nil {
+			return err
+		}
+	}
+	for path, events := range byObject {
+		if err := w.writeFile(path+eventsFileSuffix, formatEvents(events)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatEvents returns the events as a chronological table.
+func formatEvents(events []event) []byte {
+	slices.SortFunc(events, func(a, b event) int {
+		return cmp.Or(a.time.Compare(b.time), cmp.Compare(a.name, b.name))
+	})
+
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tTYPE\tREASON\tOBJECT\tCOUNT\tMESSAGE")
+	for _, e := range events {
+		timestamp := "-"
+		if !e.time.IsZero() {
+			timestamp = e.time.UTC().Format(time.RFC3339)
+		}
+		count := "-"
+		if e.count > 0 {
+			count = fmt.Sprint(e.count)
+		}
+		// keep one line per event
+		message := strings.Join(strings.Fields(e.message), " ")
+
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v/%v\t%v\t%v\n", timestamp, e.eventType, e.reason, strings.ToLower(e.object.kind), e.object.name, count, message)
+	}
+	tw.Flush()
+	return buf.Bytes()
+}
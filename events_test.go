@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestDedupeEvents(t *testing.T) {
+	var (
+		coreEvents = apiResource{gvr: schema.GroupVersionResource{Version: "v1", Resource: "events"}, namespaced: true}
+		newEvents  = apiResource{gvr: schema.GroupVersionResource{Group: "events.k8s.io", Version: "v1", Resource: "events"}, namespaced: true}
+		pods       = apiResource{gvr: schema.GroupVersionResource{Version: "v1", Resource: "pods"}, namespaced: true}
+	)
+
+	tests := []struct {
+		name           string
+		resources      []apiResource
+		wantKept       []apiResource
+		wantDuplicates []apiResource
+	}{
+		{
+			name:           "both APIs",
+			resources:      []apiResource{coreEvents, pods, newEvents},
+			wantKept:       []apiResource{pods, newEvents},
+			wantDuplicates: []apiResource{coreEvents},
+		},
+		{
+			name:      "core only",
+			resources: []apiResource{coreEvents, pods},
+			wantKept:  []apiResource{coreEvents, pods},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, duplicates := dedupeEvents(tt.resources)
+			if !reflect.DeepEqual(kept, tt.wantKept) {
+				t.Errorf("got kept %v, want %v", kept, tt.wantKept)
+			}
+			if !reflect.DeepEqual(duplicates, tt.wantDuplicates) {
+				t.Errorf("got duplicates %v, want %v", duplicates, tt.wantDuplicates)
+			}
+		})
+	}
+}
+
+func TestEventTimeline(t *testing.T) {
+	newEvent := func(apiVersion, name string, fields map[string]any) unstructured.Unstructured {
+		obj := map[string]any{
+			"apiVersion": apiVersion,
+			"kind":       "Event",
+			"metadata":   map[string]any{"name": name, "namespace": "default"},
+		}
+		for k, v := range fields {
+			obj[k] = v
+		}
+		return unstructured.Unstructured{Object: obj}
+	}
+
+	var (
+		coreGVR = schema.GroupVersionResource{Version: "v1", Resource: "events"}
+		newGVR  = schema.GroupVersionResource{Group: "events.k8s.io", Version: "v1", Resource: "events"}
+		pod     = map[string]any{"apiVersion": "v1", "kind": "Pod", "namespace": "default", "name": "web-1"}
+		items   = []struct {
+			item unstructured.Unstructured
+			gvr  schema.GroupVersionResource
+		}{
+			{newEvent("v1", "web-1.2", map[string]any{"involvedObject": pod, "type": "Warning", "reason": "BackOff", "message": "Back-off restarting\nfailed container", "count": int64(3), "lastTimestamp": "2024-01-01T00:02:00Z"}), coreGVR},
+			{newEvent("events.k8s.io/v1", "web-1.2", map[string]any{"regarding": pod, "type": "Warning", "reason": "BackOff", "note": "Back-off restarting\nfailed container", "deprecatedCount": int64(3), "deprecatedLastTimestamp": "2024-01-01T00:02:00Z"}), newGVR},
+			{newEvent("events.k8s.io/v1", "web-1.1", map[string]any{"regarding": pod, "type": "Normal", "reason": "Scheduled", "note": "Successfully assigned", "eventTime": "2024-01-01T00:00:30.000000Z"}), newGVR},
+			{newEvent("v1", "other.1", map[string]any{"involvedObject": map[string]any{"kind": "Pod", "namespace": "default", "name": "other"}, "type": "Normal", "reason": "Pulled", "series": map[string]any{"count": int64(2), "lastObservedTime": "2024-01-01T00:01:00.000000Z"}}), coreGVR},
+		}
+	)
+
+	timeline := newEventTimeline()
+	for _, i := range items {
+		if err := timeline.addEvent(i.item, i.gvr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	outDir := t.TempDir()
+	podPath := filepath.Join(outDir, "namespaced", "default", "pods", "web-1")
+	timeline.addObject("", "Pod", "default", "web-1", podPath)
+
+	if err := timeline.write(fileWriter{fileMode: 0o600, dirMode: 0o700, uid: -1, gid: -1}, outDir); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{
+			filename: filepath.Join(outDir, "namespaced", "default", eventsFilename),
+			want: "TIME                  TYPE     REASON     OBJECT     COUNT  MESSAGE\n" +
+				"2024-01-01T00:00:30Z  Normal   Scheduled  pod/web-1  -      Successfully assigned\n" +
+				"2024-01-01T00:01:00Z  Normal   Pulled     pod/other  2      \n" +
+				"2024-01-01T00:02:00Z  Warning  BackOff    pod/web-1  3      Back-off restarting failed container\n",
+		},
+		{
+			filename: podPath + eventsFileSuffix,
+			want: "TIME                  TYPE     REASON     OBJECT     COUNT  MESSAGE\n" +
+				"2024-01-01T00:00:30Z  Normal   Scheduled  pod/web-1  -      Successfully assigned\n" +
+				"2024-01-01T00:02:00Z  Warning  BackOff    pod/web-1  3      Back-off restarting failed container\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(filepath.Base(tt.filename), func(t *testing.T) {
+			got, err := os.ReadFile(tt.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// extractedDataAnnotation references the files of the extracted keys.
+	extractedDataAnnotation = "kubedump.sj14.github.io/extracted-data"
+	extractedDataSuffix     = ".data"
+)
+
+var configMapsGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+// extractedData is the value of the extracted data annotation. The files contain the plain values,
+// the values of the data of secrets and the binary data of config maps are decoded.
+type extractedData struct {
+	Dir        string   `json:"dir"` // relative to the manifest
+	Data       []string `json:"data,omitempty"`
+	BinaryData []string `json:"binaryData,omitempty"`
+}
+
+// isDataResource reports whether the data of the resource can be extracted.
+func isDataResource(gvr schema.GroupVersionResource) bool {
+	return gvr == configMapsGVR || gvr == secretsGVR
+}
+
+// extractData writes each key of the data and the binary data of the config map or secret to a file
+// in the '<name>.data' directory next to the manifest, removes them from the item and references them
+// with an annotation instead. It returns the number of written bytes.
+func extractData(w fileWriter, manifestPath string, item unstructured.Unstructured) (int, error) {
+	var (
+		dir       = manifestPath + extractedDataSuffix
+		extracted = extractedData{Dir: filepath.Base(dir)}
+		written   int
+	)
+
+	secret := item.GetKind() == "Secret"
+	for _, field := range []string{"data", "binaryData"} {
+		values, _, err := unstructured.NestedStringMap(item.Object, field)
+		if err != nil {
+			return written, fmt.Errorf("failed getting %v: %v", field, err)
+		}
+
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+
+		for _, key := range keys {
+			// kept inline, the key can't be used as filename
+			if key == "" || key == "." || key == ".." || filepath.Base(key) != key {
+				continue
+			}
+
+			content := []byte(values[key])
+			if secret || field == "binaryData" {
+				if content, err = base64.StdEncoding.DecodeString(values[key]); err != nil {
+					continue // kept inline
+				}
+			}
+
+			if err := w.writeFile(filepath.Join(dir, key), content); err != nil {
+				return written, err
+			}
+			written += len(content)
+
+			delete(values, key)
+			if field == "data" {
+				extracted.Data = append(extracted.Data, key)
+			} else {
+				extracted.BinaryData = append(extracted.BinaryData, key)
+			}
+		}
+
+		if len(values) == 0 {
+			unstructured.RemoveNestedField(item.Object, field)
+		} else if err := unstructured.SetNestedStringMap(item.Object, values, field); err != nil {
+			return written, fmt.Errorf("failed setting %v: %v", field, err)
+		}
+	}
+
+	if len(extracted.Data) == 0 && len(extracted.BinaryData) == 0 {
+		return written, nil
+	}
+
+	annotation, err := json.Marshal(extracted)
+	if err != nil {
+		return written, fmt.Errorf("failed marshalling annotation: %v", err)
+	}
+	annotations := item.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[extractedDataAnnotation] = string(annotation)
+	item.SetAnnotations(annotations)
+
+	return written, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestExtractData(t *testing.T) {
+	tests := []struct {
+		name           string
+		item           unstructured.Unstructured
+		wantFiles      map[string]string
+		wantAnnotation string
+		wantObject     map[string]any // data and binaryData remaining in the manifest
+	}{
+		{
+			name: "config map",
+			item: unstructured.Unstructured{Object: map[string]any{
+				"kind":       "ConfigMap",
+				"metadata":   map[string]any{"name": "app"},
+				"data":       map[string]any{"nginx.conf": "server {}\n", "..": "kept"},
+				"binaryData": map[string]any{"bin": "AAEC"},
+			}},
+			wantFiles:      map[string]string{"nginx.conf": "server {}\n", "bin": "\x00\x01\x02"},
+			wantAnnotation: `{"dir":"app.data","data":["nginx.conf"],"binaryData":["bin"]}`,
+			wantObject:     map[string]any{"data": map[string]any{"..": "kept"}},
+		},
+		{
+			name: "secret",
+			item: unstructured.Unstructured{Object: map[string]any{
+				"kind":     "Secret",
+				"metadata": map[string]any{"name": "app"},
+				"data":     map[string]any{"password": "c2VjcmV0", "invalid": "%%%"},
+			}},
+			wantFiles:      map[string]string{"password": "secret"},
+			wantAnnotation: `{"dir":"app.data","data":["password"]}`,
+			wantObject:     map[string]any{"data": map[string]any{"invalid": "%%%"}},
+		},
+		{
+			name: "empty",
+			item: unstructured.Unstructured{Object: map[string]any{
+				"kind":     "ConfigMap",
+				"metadata": map[string]any{"name": "app"},
+			}},
+			wantObject: map[string]any{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifestPath := filepath.Join(t.TempDir(), "app")
+			w := fileWriter{fileMode: 0o600, dirMode: 0o700, uid: -1, gid: -1}
+
+			if _, err := extractData(w, manifestPath, tt.item); err != nil {
+				t.Fatal(err)
+			}
+
+			for filename, want := range tt.wantFiles {
+				got, err := os.ReadFile(filepath.Join(manifestPath+extractedDataSuffix, filename))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != want {
+					t.Errorf("got content %q of %v, want %q", got, filename, want)
+				}
+			}
+
+			if got := tt.item.GetAnnotations()[extractedDataAnnotation]; got != tt.wantAnnotation {
+				t.Errorf("got annotation %v, want %v", got, tt.wantAnnotation)
+			}
+
+			gotObject := make(map[string]any)
+			for _, field := range []string{"data", "binaryData"} {
+				if value, ok := tt.item.Object[field]; ok {
+					gotObject[field] = value
+				}
+			}
+			if !reflect.DeepEqual(gotObject, tt.wantObject) {
+				t.Errorf("got %v, want %v", gotObject, tt.wantObject)
+			}
+		})
+	}
+}
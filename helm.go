@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	helmReleaseType = "helm.sh/release.v1"
+	helmDir         = "helm"
+)
+
+var secretsGVR = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+
+// helmRelease contains the fields of a Helm v3 release relevant for the dump.
+type helmRelease struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Version   int    `json:"version"`
+	Info      struct {
+		FirstDeployed time.Time `json:"first_deployed"`
+		LastDeployed  time.Time `json:"last_deployed"`
+		Description   string    `json:"description"`
+		Status        string    `json:"status"`
+		Notes         string    `json:"notes"`
+	} `json:"info"`
+	Chart struct {
+		Metadata struct {
+			Name       string `json:"name"`
+			Version    string `json:"version"`
+			AppVersion string `json:"appVersion"`
+		} `json:"metadata"`
+	} `json:"chart"`
+	Config   map[string]any `json:"config"` // the user-supplied values
+	Manifest string         `json:"manifest"`
+}
+
+// helmRevision is the metadata of a release revision, written as release.yaml and in the history.
+type helmRevision struct {
+	Name          string    `json:"name"`
+	Namespace     string    `json:"namespace"`
+	Revision      int       `json:"revision"`
+	Status        string    `json:"status"`
+	Chart         string    `json:"chart"`
+	ChartVersion  string    `json:"chartVersion"`
+	AppVersion    string    `json:"appVersion,omitempty"`
+	FirstDeployed time.Time `json:"firstDeployed"`
+	LastDeployed  time.Time `json:"lastDeployed"`
+	Description   string    `json:"description,omitempty"`
+	Notes         string    `json:"notes,omitempty"`
+}
+
+// helmReleases collects the revisions of the releases for writing their histories.
+type helmReleases struct {
+	mu        sync.Mutex
+	revisions map[string][]helmRevision // by namespace/name
+}
+
+func newHelmReleases() *helmReleases {
+	return &helmReleases{revisions: make(map[string][]helmRevision)}
+}
+
+// isHelmRelease reports whether the item is a secret containing a Helm v3 release.
+func isHelmRelease(gvr schema.GroupVersionResource, item unstructured.Unstructured) bool {
+	secretType, _, _ := unstructured.NestedString(item.Object, "type")
+	return gvr == secretsGVR && secretType == helmReleaseType
+}
+
+// decodeHelmRelease decodes the release of the secret. Helm stores it as base64 encoded,
+// gzipped JSON, which is base64 encoded once more as secret data.
+func decodeHelmRelease(item unstructured.Unstructured) (*helmRelease, error) {
+	data, found, err := unstructured.NestedString(item.Object, "data", "release")
+	if err != nil || !found {
+		return nil, fmt.Errorf("missing release data")
+	}
+
+	helmData, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding secret data: %v", err)
+	}
+	releaseData, err := base64.StdEncoding.DecodeString(string(helmData))
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding release: %v", err)
+	}
+
+	// old releases might not be compressed
+	if bytes.HasPrefix(releaseData, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(bytes.NewReader(releaseData))
+		if err != nil {
+			return nil, fmt.Errorf("failed decompressing release: %v", err)
+		}
+		if releaseData, err = io.ReadAll(gz); err != nil {
+			return nil, fmt.Errorf("failed decompressing release: %v", err)
+		}
+	}
+
+	var release helmRelease
+	if err := json.Unmarshal(releaseData, &release); err != nil {
+		return nil, fmt.Errorf("failed unmarshalling release: %v", err)
+	}
+	// the names are used as directories
+	if errs := validation.IsDNS1123Subdomain(release.Name); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid release name %q: %v", release.Name, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsDNS1123Label(release.Namespace); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid release namespace %q: %v", release.Namespace, strings.Join(errs, ", "))
+	}
+	return &release, nil
+}
+
+func (r *helmRelease) revision() helmRevision {
+	return helmRevision{
+		Name:          r.Name,
+		Namespace:     r.Namespace,
+		Revision:      r.Version,
+		Status:        r.Info.Status,
+		Chart:         r.Chart.Metadata.Name,
+		ChartVersion:  r.Chart.Metadata.Version,
+		AppVersion:    r.Chart.Metadata.AppVersion,
+		FirstDeployed: r.Info.FirstDeployed,
+		LastDeployed:  r.Info.LastDeployed,
+		Description:   r.Info.Description,
+		Notes:         r.Info.Notes,
+	}
+}
+
+// releaseDir returns the directory of the release in the output directory.
+func releaseDir(outDir, namespace, name string) string {
+	return filepath.Join(outDir, helmDir, namespace, name)
+}
+
+// writeRelease writes the values, the manifest and the metadata of the release revision
+// to 'helm/<namespace>/<release>/<revision>/' and adds the revision to the history.
+func (h *helmReleases) writeRelease(w fileWriter, outDir string, release *helmRelease) error {
+	dir := filepath.Join(releaseDir(outDir, release.Namespace, release.Name), strconv.Itoa(release.Version))
+
+	values, err := yaml.Marshal(release.Config)
+	if err != nil {
+		return fmt.Errorf("failed marshalling values: %v", err)
+	}
+	revision := release.revision()
+	metadata, err := yaml.Marshal(revision)
+	if err != nil {
+		return fmt.Errorf("failed marshalling release: %v", err)
+	}
+
+	for filename, content := range map[string][]byte{
+		"values.yaml":   values,
+		"manifest.yaml": []byte(release.Manifest),
+		"release.yaml":  metadata,
+	} {
+		if err := w.writeFile(filepath.Join(dir, filename), content); err != nil {
+			return err
+		}
+	}
+
+	revision.Notes = "" // only in the release.yaml of the revision
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := release.Namespace + "/" + release.Name
+	h.revisions[key] = append(h.revisions[key], revision)
+	return nil
+}
+
+// writeHistories writes the revisions of each release to 'helm/<namespace>/<release>/history.yaml'.
+func (h *helmReleases) writeHistories(w fileWriter, outDir string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, revisions := range h.revisions {
+		slices.SortFunc(revisions, func(a, b helmRevision) int { return cmp.Compare(a.Revision, b.Revision) })
+
+		history, err := yaml.Marshal(revisions)
+		if err != nil {
+			return fmt.Errorf("failed marshalling history: %v", err)
+		}
+		if err := w.writeFile(filepath.Join(releaseDir(outDir, revisions[0].Namespace, revisions[0].Name), "history.yaml"), history); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDecodeHelmRelease(t *testing.T) {
+	newSecret := func(release string, compress bool) unstructured.Unstructured {
+		data := []byte(release)
+		if compress {
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			gz.Write(data)
+			gz.Close()
+			data = buf.Bytes()
+		}
+		helmData := base64.StdEncoding.EncodeToString(data)
+		return unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"type":       helmReleaseType,
+			"data":       map[string]any{"release": base64.StdEncoding.EncodeToString([]byte(helmData))},
+		}}
+	}
+
+	const release = `{"name":"web","namespace":"default","version":2,"info":{"status":"deployed"},"chart":{"metadata":{"name":"nginx","version":"1.2.3"}},"config":{"replicas":2},"manifest":"kind: ConfigMap\n"}`
+
+	tests := []struct {
+		name    string
+		secret  unstructured.Unstructured
+		wantErr bool
+	}{
+		{name: "compressed", secret: newSecret(release, true)},
+		{name: "uncompressed", secret: newSecret(release, false)},
+		{name: "invalid name", secret: newSecret(`{"name":"../web","namespace":"default"}`, true), wantErr: true},
+		{name: "invalid json", secret: newSecret(`{`, true), wantErr: true},
+		{name: "missing data", secret: unstructured.Unstructured{Object: map[string]any{"type": helmReleaseType}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeHelmRelease(tt.secret)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got.Name != "web" || got.Namespace != "default" || got.Version != 2 {
+				t.Errorf("got release %v/%v revision %v, want default/web revision 2", got.Namespace, got.Name, got.Version)
+			}
+			if got.Chart.Metadata.Name != "nginx" || got.Chart.Metadata.Version != "1.2.3" {
+				t.Errorf("got chart %v %v, want nginx 1.2.3", got.Chart.Metadata.Name, got.Chart.Metadata.Version)
+			}
+			if got.Config["replicas"] != float64(2) {
+				t.Errorf("got values %v, want replicas 2", got.Config)
+			}
+			if got.Manifest != "kind: ConfigMap\n" {
+				t.Errorf("got manifest %q", got.Manifest)
+			}
+		})
+	}
+}
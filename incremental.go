@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// stateFilename is the state of the previous incremental dump in the output directory.
+const stateFilename = ".kubedump-state.json"
+
+// dumpState is the content of the state file.
+type dumpState struct {
+	Options stateOptions           `json:"options"`
+	Objects map[string]objectState `json:"objects"` // by path, relative to the output directory and without extension
+}
+
+// stateOptions are the options changing the written files, the previous state is discarded when they differ.
+type stateOptions struct {
+	Version         string `json:"version"`
+	Stateless       bool   `json:"stateless"`
+	Layout          string `json:"layout"`
+	KeyOrder        string `json:"keyOrder"`
+	ExtractData     bool   `json:"extractData"`
+	ChartValues     bool   `json:"chartValues"`
+	SkipHelmSecrets bool   `json:"skipHelmSecrets"`
+	Events          bool   `json:"events"`
+}
+
+func newStateOptions(opts options) stateOptions {
+	return stateOptions{
+		Version:         version,
+		Stateless:       opts.stateless,
+		Layout:          opts.layout,
+		KeyOrder:        opts.keyOrder,
+		ExtractData:     opts.extractData,
+		ChartValues:     opts.chartValues,
+		SkipHelmSecrets: opts.skipHelmSecrets,
+		Events:          opts.events,
+	}
+}
+
+// objectState is the state of a dumped object. An object can be dumped several times,
+// e.g. events as core and as events.k8s.io events.
+type objectState struct {
+	UID             types.UID `json:"uid"`
+	Resource        string    `json:"resource"` // resource and group
+	Namespace       string    `json:"namespace,omitempty"`
+	ResourceVersion string    `json:"resourceVersion"`
+	Hash            string    `json:"hash"` // of the object, without the state when dumping stateless
+}
+
+// incrementalState compares the objects with the ones of the previous dump into the same directory.
+type incrementalState struct {
+	outDir   string
+	previous map[string]objectState // empty for the first dump or when the options changed
+
+	mu      sync.Mutex
+	current dumpState
+	seen    map[types.UID]bool  // all listed objects, also the filtered ones
+	listed  map[string][]string // namespaces of the listed resources, nil when listed cluster-wide
+}
+
+// loadState reads the state of the previous dump from outDir.
+func loadState(outDir string, opts options) (*incrementalState, error) {
+	s := &incrementalState{
+		outDir:   outDir,
+		previous: make(map[string]objectState),
+		current:  dumpState{Options: newStateOptions(opts), Objects: make(map[string]objectState)},
+		seen:     make(map[types.UID]bool),
+		listed:   make(map[string][]string),
+	}
+
+	content, err := os.ReadFile(filepath.Join(outDir, stateFilename))
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, fmt.Errorf("failed reading state: %v", err)
+	}
+
+	var previous dumpState
+	if err := json.Unmarshal(content, &previous); err != nil {
+		return s, fmt.Errorf("failed unmarshalling state: %v", err)
+	}
+	if previous.Options == s.current.Options {
+		s.previous = previous.Objects
+	}
+	return s, nil
+}
+
+// addListed marks the resource as listed, in the given namespaces or cluster-wide when there are none.
+func (s *incrementalState) addListed(resourceAndGroup string, namespaces []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listed[resourceAndGroup] = namespaces
+}
+
+// see marks the object as still existing.
+func (s *incrementalState) see(item unstructured.Unstructured) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seen[item.GetUID()] = true
+}
+
+// compare returns the state of the object and whether it's unchanged since the previous dump,
+// i.e. it has the same resource version or content and its manifest still exists.
+// The item is compared before it's modified for writing.
+// Objects without uid aren't tracked and the returned state is empty.
+func (s *incrementalState) compare(resourceAndGroup, manifestPath string, item unstructured.Unstructured, stateless bool) (objectState, bool, error) {
+	if item.GetUID() == "" {
+		return objectState{}, false, nil
+	}
+
+	obj := objectState{
+		UID:             item.GetUID(),
+		Resource:        resourceAndGroup,
+		Namespace:       item.GetNamespace(),
+		ResourceVersion: item.GetResourceVersion(),
+	}
+
+	previous, ok := s.previous[s.relPath(manifestPath)]
+	if ok {
+		// the manifest might have been removed since
+		_, err := os.Stat(manifestPath + ".yaml")
+		ok = err == nil
+	}
+	if ok && previous.UID == obj.UID && previous.ResourceVersion == obj.ResourceVersion {
+		obj.Hash = previous.Hash
+		return obj, true, nil
+	}
+
+	// the resource version also changes with the status, which isn't dumped when stateless
+	compared := item.DeepCopy()
+	if stateless {
+		cleanState(*compared)
+	}
+	content, err := json.Marshal(compared.Object)
+	if err != nil {
+		return obj, false, fmt.Errorf("failed marshalling: %v", err)
+	}
+	sum := sha256.Sum256(content)
+	obj.Hash = hex.EncodeToString(sum[:])
+
+	return obj, ok && previous.Hash == obj.Hash, nil
+}
+
+// add adds the object dumped to the manifest path to the state.
+func (s *incrementalState) add(manifestPath string, obj objectState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current.Objects[s.relPath(manifestPath)] = obj
+}
+
+// relPath returns the manifest path relative to the output directory, the key of the state.
+func (s *incrementalState) relPath(manifestPath string) string {
+	path, err := filepath.Rel(s.outDir, manifestPath)
+	if err != nil {
+		return manifestPath // not reached, the manifests are in the output directory
+	}
+	return filepath.ToSlash(path)
+}
+
+// removeDeleted removes the manifests of the previous dump whose objects don't exist anymore
+// and returns their number. Objects of resources or namespaces which weren't listed are kept.
+func (s *incrementalState) removeDeleted() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removed int
+	for path, obj := range s.previous {
+		if _, ok := s.current.Objects[path]; ok {
+			continue
+		}
+
+		namespaces, listed := s.listed[obj.Resource]
+		if s.seen[obj.UID] || !listed || namespaces != nil && !slices.Contains(namespaces, obj.Namespace) {
+			// e.g. filtered or failed in this dump
+			s.current.Objects[path] = obj
+			continue
+		}
+
+		path := filepath.Join(s.outDir, filepath.FromSlash(path))
+		for _, filename := range []string{path + ".yaml", path + eventsFileSuffix} {
+			if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+				return removed, fmt.Errorf("failed removing file of deleted object: %v", err)
+			}
+		}
+		// the extracted data and the directory with the logs of a pod
+		for _, dir := range []string{path + extractedDataSuffix, path} {
+			if err := os.RemoveAll(dir); err != nil {
+				return removed, fmt.Errorf("failed removing files of deleted object: %v", err)
+			}
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// write writes the state of the current dump.
+func (s *incrementalState) write(w fileWriter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	content, err := json.Marshal(s.current)
+	if err != nil {
+		return fmt.Errorf("failed marshalling state: %v", err)
+	}
+	return w.writeFile(filepath.Join(s.outDir, stateFilename), content)
+}
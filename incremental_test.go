@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func stateItem(uid, namespace, name, resourceVersion, image, phase string) unstructured.Unstructured {
+	return unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]any{
+			"uid":             uid,
+			"namespace":       namespace,
+			"name":            name,
+			"resourceVersion": resourceVersion,
+		},
+		"spec":   map[string]any{"containers": []any{map[string]any{"name": "app", "image": image}}},
+		"status": map[string]any{"phase": phase},
+	}}
+}
+
+func TestIncrementalState(t *testing.T) {
+	outDir := t.TempDir()
+	w := fileWriter{fileMode: 0o600, dirMode: 0o700, uid: -1, gid: -1}
+	opts := options{stateless: true, layout: layoutDefault, keyOrder: keyOrderAlphabetical}
+	names := newNamer(layoutDefault)
+
+	items := []unstructured.Unstructured{
+		stateItem("uid-web", "default", "web", "1", "nginx:1", "Pending"),
+		stateItem("uid-db", "default", "db", "1", "postgres:1", "Running"),
+		stateItem("uid-other", "other", "other", "1", "busybox:1", "Running"),
+	}
+
+	// dumps the items and returns whether each was unchanged
+	dump := func(opts options, listed []string, items ...unstructured.Unstructured) (map[string]bool, int) {
+		t.Helper()
+		state, err := loadState(outDir, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state.addListed("pods", listed)
+
+		unchanged := make(map[string]bool)
+		for _, original := range items {
+			// written like in a dump, which modifies the item
+			item := *original.DeepCopy()
+			state.see(item)
+			manifestPath := names.objectPath(outDir, "pods", item.GetNamespace(), item.GetName())
+			obj, same, err := state.compare("pods", manifestPath, item, opts.stateless)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !same {
+				if _, err := writeYAML(w, manifestPath, item, opts); err != nil {
+					t.Fatal(err)
+				}
+			}
+			state.add(manifestPath, obj)
+			unchanged[item.GetName()] = same
+		}
+
+		removed, err := state.removeDeleted()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := state.write(w); err != nil {
+			t.Fatal(err)
+		}
+		return unchanged, removed
+	}
+
+	check := func(got map[string]bool, want map[string]bool) {
+		t.Helper()
+		for name, wantUnchanged := range want {
+			if got[name] != wantUnchanged {
+				t.Errorf("got unchanged = %v for %q, want %v", got[name], name, wantUnchanged)
+			}
+		}
+	}
+
+	got, _ := dump(opts, nil, items...)
+	check(got, map[string]bool{"web": false, "db": false, "other": false})
+
+	got, _ = dump(opts, nil, items...)
+	check(got, map[string]bool{"web": true, "db": true, "other": true})
+
+	// only the status changed, which isn't dumped
+	items[0] = stateItem("uid-web", "default", "web", "2", "nginx:1", "Running")
+	// the spec changed
+	items[1] = stateItem("uid-db", "default", "db", "2", "postgres:2", "Running")
+	// the manifest was removed
+	if err := os.Remove(filepath.Join(outDir, "namespaced", "other", "pods", "other.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = dump(opts, nil, items...)
+	check(got, map[string]bool{"web": true, "db": false, "other": false})
+
+	// the status is dumped with other options
+	statefulOpts := opts
+	statefulOpts.stateless = false
+	got, _ = dump(statefulOpts, nil, items...)
+	check(got, map[string]bool{"web": false, "db": false, "other": false})
+	got, _ = dump(statefulOpts, nil, items...)
+	check(got, map[string]bool{"web": true, "db": true, "other": true})
+
+	// db was deleted, other wasn't listed as only the default namespace was
+	dbPath := filepath.Join(outDir, "namespaced", "default", "pods", "db")
+	for _, filename := range []string{dbPath + eventsFileSuffix, filepath.Join(dbPath, "app.log")} {
+		if err := w.writeFile(filename, []byte("written with the manifest")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, removed := dump(statefulOpts, []string{"default"}, items[0])
+	if removed != 1 {
+		t.Errorf("got %v removed, want 1", removed)
+	}
+	for _, filename := range []string{dbPath + ".yaml", dbPath + eventsFileSuffix, dbPath} {
+		if _, err := os.Stat(filename); !os.IsNotExist(err) {
+			t.Errorf("%v of the deleted object wasn't removed: %v", filename, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "namespaced", "other", "pods", "other.yaml")); err != nil {
+		t.Errorf("manifest of the not listed object was removed: %v", err)
+	}
+
+	// other is still in the state
+	got, _ = dump(statefulOpts, nil, items[2])
+	check(got, map[string]bool{"other": true})
+}
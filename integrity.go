@@ -0,0 +1,347 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	checksumsFilename = "SHA256SUMS"
+	// signatureFilename contains the raw ed25519 signature of the checksums file.
+	signatureFilename = checksumsFilename + ".sig"
+)
+
+// hashFiles returns the SHA-256 of the regular files in fsys by their slash-separated paths,
+// except the checksums, the signature and the failed marker, which is added after the dump, in the root.
+func hashFiles(fsys fs.FS) (map[string]string, error) {
+	hashes := make(map[string]string)
+	err := fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && atomicDirLeftover(name) {
+			return fs.SkipDir
+		}
+		if !entry.Type().IsRegular() || name == checksumsFilename || name == signatureFilename || name == failedMarker {
+			return nil
+		}
+
+		f, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		hash, err := hashReader(f)
+		if err != nil {
+			return fmt.Errorf("failed reading %q: %v", name, err)
+		}
+		hashes[name] = hash
+		return nil
+	})
+	return hashes, err
+}
+
+// atomicDirLeftover reports whether the directory in the root of a dump is the staging or the previous
+// directory of a cluster dumped with -atomic-dir, which doesn't belong to the dump.
+func atomicDirLeftover(dir string) bool {
+	return !strings.Contains(dir, "/") && (strings.HasSuffix(dir, stagingSuffix) || strings.HasSuffix(dir, previousSuffix))
+}
+
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// formatChecksums formats the hashes like sha256sum, sorted by path.
+func formatChecksums(hashes map[string]string) []byte {
+	names := make([]string, 0, len(hashes))
+	for name := range hashes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var b bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s  %s\n", hashes[name], name)
+	}
+	return b.Bytes()
+}
+
+// parseChecksums parses the output of sha256sum.
+func parseChecksums(content []byte) (map[string]string, error) {
+	hashes := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		hash, name, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok || len(hash) != sha256.Size*2 {
+			return nil, fmt.Errorf("invalid line %q", scanner.Text())
+		}
+		hashes[name] = hash
+	}
+	return hashes, scanner.Err()
+}
+
+// writeChecksums writes the checksums of all files in outDir, including the report,
+// and signs them when a key is given. It has to be called after all files were written.
+func writeChecksums(w fileWriter, outDir string, key ed25519.PrivateKey) error {
+	hashes, err := hashFiles(os.DirFS(outDir))
+	if err != nil {
+		return fmt.Errorf("failed hashing files: %v", err)
+	}
+
+	checksums := formatChecksums(hashes)
+	if err := w.writeFile(filepath.Join(outDir, checksumsFilename), checksums); err != nil {
+		return err
+	}
+
+	if key == nil {
+		return nil
+	}
+	return w.writeFile(filepath.Join(outDir, signatureFilename), ed25519.Sign(key, checksums))
+}
+
+// parseSigningKey parses a PEM encoded PKCS #8 ed25519 private key, e.g. generated with 'openssl genpkey -algorithm ed25519'.
+func parseSigningKey(content []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("got %T, want an ed25519 key", key)
+	}
+	return privateKey, nil
+}
+
+// parseVerifyKey parses a PEM encoded PKIX ed25519 public key, e.g. generated with 'openssl pkey -pubout'.
+func parseVerifyKey(content []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("got %T, want an ed25519 key", key)
+	}
+	return publicKey, nil
+}
+
+// dumpContent are the hashes of the files of a dump, with the content of its checksums and signature.
+type dumpContent struct {
+	hashes    map[string]string
+	checksums []byte // nil when missing
+	signature []byte // nil when missing
+}
+
+// readDumpDir reads the dump in dir.
+func readDumpDir(dir string) (dumpContent, error) {
+	var content dumpContent
+	var err error
+	if content.hashes, err = hashFiles(os.DirFS(dir)); err != nil {
+		return content, fmt.Errorf("failed hashing files: %v", err)
+	}
+	for filename, dst := range map[string]*[]byte{checksumsFilename: &content.checksums, signatureFilename: &content.signature} {
+		if *dst, err = os.ReadFile(filepath.Join(dir, filename)); err != nil && !os.IsNotExist(err) {
+			return content, err
+		}
+	}
+	return content, nil
+}
+
+// readDumpArchive reads the dump in a tar archive, optionally gzipped. The dump is
+// the directory of the archive with the least nested checksums file.
+func readDumpArchive(r io.Reader) (dumpContent, error) {
+	buffered := bufio.NewReader(r)
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return dumpContent{}, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = buffered
+	}
+
+	var (
+		hashes     = make(map[string]string)
+		checksums  = make(map[string][]byte) // by dir
+		signatures = make(map[string][]byte) // by dir
+	)
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return dumpContent{}, fmt.Errorf("failed reading archive: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := strings.TrimPrefix(path.Clean(header.Name), "/")
+		dir, base := path.Split(name)
+
+		var hash string
+		if base == checksumsFilename || base == signatureFilename {
+			// they are hashed as well, unless they belong to the dump itself
+			var content []byte
+			if content, err = io.ReadAll(archive); err == nil {
+				hash, err = hashReader(bytes.NewReader(content))
+			}
+			if base == checksumsFilename {
+				checksums[dir] = content
+			} else {
+				signatures[dir] = content
+			}
+		} else {
+			hash, err = hashReader(archive)
+		}
+		if err != nil {
+			return dumpContent{}, fmt.Errorf("failed reading %q: %v", name, err)
+		}
+		hashes[name] = hash
+	}
+
+	root := ""
+	if len(checksums) > 0 {
+		dirs := make([]string, 0, len(checksums))
+		for dir := range checksums {
+			dirs = append(dirs, dir)
+		}
+		slices.SortFunc(dirs, func(a, b string) int {
+			return cmp.Or(cmp.Compare(strings.Count(a, "/"), strings.Count(b, "/")), cmp.Compare(a, b))
+		})
+		root = dirs[0]
+	}
+
+	content := dumpContent{
+		hashes:    make(map[string]string),
+		checksums: checksums[root],
+		signature: signatures[root],
+	}
+	for name, hash := range hashes {
+		rel, ok := strings.CutPrefix(name, root)
+		if !ok || rel == checksumsFilename || rel == signatureFilename || rel == failedMarker {
+			continue
+		}
+		if dir, _, nested := strings.Cut(rel, "/"); nested && atomicDirLeftover(dir) {
+			continue
+		}
+		content.hashes[rel] = hash
+	}
+	return content, nil
+}
+
+// verify compares the files with the checksums and verifies the signature when a key is given.
+// It returns the problems found, none when the dump is intact.
+func (c dumpContent) verify(key ed25519.PublicKey) ([]string, error) {
+	if c.checksums == nil {
+		return nil, fmt.Errorf("%v not found", checksumsFilename)
+	}
+
+	var problems []string
+	if key != nil {
+		if c.signature == nil {
+			problems = append(problems, fmt.Sprintf("%v: missing", signatureFilename))
+		} else if !ed25519.Verify(key, c.checksums, c.signature) {
+			problems = append(problems, fmt.Sprintf("%v: invalid signature", signatureFilename))
+		}
+	}
+
+	want, err := parseChecksums(c.checksums)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing %v: %v", checksumsFilename, err)
+	}
+	for name, hash := range want {
+		got, ok := c.hashes[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%v: missing", name))
+		} else if got != hash {
+			problems = append(problems, fmt.Sprintf("%v: checksum mismatch", name))
+		}
+	}
+	for name := range c.hashes {
+		if _, ok := want[name]; !ok {
+			problems = append(problems, fmt.Sprintf("%v: not in %v", name, checksumsFilename))
+		}
+	}
+	slices.Sort(problems)
+	return problems, nil
+}
+
+// verifyDump verifies the dump in the directory or archive and prints the result.
+// It returns whether the dump is intact.
+func verifyDump(target string, key ed25519.PublicKey, w io.Writer) (bool, error) {
+	info, err := os.Stat(target)
+	if err != nil {
+		return false, err
+	}
+
+	var content dumpContent
+	if info.IsDir() {
+		content, err = readDumpDir(target)
+	} else {
+		var f *os.File
+		if f, err = os.Open(target); err != nil {
+			return false, err
+		}
+		defer f.Close()
+		content, err = readDumpArchive(f)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	problems, err := content.verify(key)
+	if err != nil {
+		return false, err
+	}
+	for _, problem := range problems {
+		fmt.Fprintln(w, problem)
+	}
+	if len(problems) > 0 {
+		return false, nil
+	}
+
+	switch {
+	case key != nil:
+		fmt.Fprintf(w, "verified %d files and the signature\n", len(content.hashes))
+	case content.signature != nil:
+		fmt.Fprintf(w, "verified %d files, the signature wasn't verified without a key\n", len(content.hashes))
+	default:
+		fmt.Fprintf(w, "verified %d files\n", len(content.hashes))
+	}
+	return true, nil
+}
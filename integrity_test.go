@@ -0,0 +1,177 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChecksums(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the keys are read from PEM files
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if privateKey, err = parseSigningKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})); err != nil {
+		t.Fatal(err)
+	}
+	pkix, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if publicKey, err = parseVerifyKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix})); err != nil {
+		t.Fatal(err)
+	}
+
+	w := fileWriter{fileMode: 0o600, dirMode: 0o700, uid: -1, gid: -1}
+	files := map[string]string{
+		reportFilename:                               "{}",
+		"namespaced/default/pods/web.yaml":           "kind: Pod\n",
+		"clusterscoped/namespaces/default.yaml":      "kind: Namespace\n",
+		"context/" + checksumsFilename:               "nested checksums are hashed\n",
+		"namespaced/default/configmaps/app.data/key": "value",
+	}
+
+	tests := []struct {
+		name   string
+		modify func(dir string) error
+		key    ed25519.PublicKey
+		want   []string
+	}{
+		{
+			name: "intact",
+			key:  publicKey,
+		},
+		{
+			name: "without key",
+		},
+		{
+			name: "modified",
+			modify: func(dir string) error {
+				return os.WriteFile(filepath.Join(dir, "namespaced/default/pods/web.yaml"), []byte("kind: Other\n"), 0o600)
+			},
+			key:  publicKey,
+			want: []string{"namespaced/default/pods/web.yaml: checksum mismatch"},
+		},
+		{
+			name: "removed and added",
+			modify: func(dir string) error {
+				if err := os.Remove(filepath.Join(dir, reportFilename)); err != nil {
+					return err
+				}
+				return os.WriteFile(filepath.Join(dir, "namespaced/default/pods/other.yaml"), []byte("kind: Pod\n"), 0o600)
+			},
+			want: []string{"namespaced/default/pods/other.yaml: not in SHA256SUMS", "report.json: missing"},
+		},
+		{
+			name: "atomic dir leftovers",
+			modify: func(dir string) error {
+				for _, leftover := range []string{"context" + stagingSuffix, "context" + previousSuffix} {
+					if err := os.MkdirAll(filepath.Join(dir, leftover), 0o700); err != nil {
+						return err
+					}
+					if err := os.WriteFile(filepath.Join(dir, leftover, reportFilename), []byte("{}"), 0o600); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			key: publicKey,
+		},
+		{
+			name: "other key",
+			key:  otherKey,
+			want: []string{"SHA256SUMS.sig: invalid signature"},
+		},
+		{
+			name: "missing signature",
+			modify: func(dir string) error {
+				return os.Remove(filepath.Join(dir, signatureFilename))
+			},
+			key:  publicKey,
+			want: []string{"SHA256SUMS.sig: missing"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "dump")
+			for name, content := range files {
+				if err := w.writeFile(filepath.Join(dir, name), []byte(content)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := writeChecksums(w, dir, privateKey); err != nil {
+				t.Fatal(err)
+			}
+			if tt.modify != nil {
+				if err := tt.modify(dir); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var out strings.Builder
+			ok, err := verifyDump(dir, tt.key, &out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != (len(tt.want) == 0) {
+				t.Errorf("got ok = %v, output:\n%s", ok, out.String())
+			}
+
+			content, err := readDumpDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := content.verify(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got problems %q, want %q", got, tt.want)
+			}
+
+			// the same dump as archive
+			archive := filepath.Join(t.TempDir(), "dump.tar.gz")
+			if err := writeTestArchive(archive, filepath.Dir(dir)); err != nil {
+				t.Fatal(err)
+			}
+			if archiveOK, err := verifyDump(archive, tt.key, &out); err != nil || archiveOK != ok {
+				t.Errorf("got ok = %v, err = %v for the archive, want %v", archiveOK, err, ok)
+			}
+		})
+	}
+}
+
+// writeTestArchive writes the files of dir to a gzipped tar archive, like 'tar czf archive -C dir .'.
+func writeTestArchive(archive, dir string) error {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	if err := tw.AddFS(os.DirFS(dir)); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(archive, buf.Bytes(), 0o600)
+}
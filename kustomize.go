@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+const kustomizationFilename = "kustomization.yaml"
+
+// kustomization is the content of a kustomization.yaml.
+type kustomization struct {
+	APIVersion string   `json:"apiVersion"`
+	Kind       string   `json:"kind"`
+	Namespace  string   `json:"namespace,omitempty"`
+	Resources  []string `json:"resources"`
+}
+
+func newKustomization(namespace string, resources []string) kustomization {
+	return kustomization{
+		APIVersion: "kustomize.config.k8s.io/v1beta1",
+		Kind:       "Kustomization",
+		Namespace:  namespace,
+		Resources:  resources,
+	}
+}
+
+// writeKustomizations writes a kustomization.yaml listing the cluster-scoped manifests, one per namespace
+// listing its manifests and setting the namespace, and one in outDir referencing them.
+// The manifests are read from the output directory, so the ones written outside of the dump are included as well.
+func writeKustomizations(w fileWriter, outDir string) error {
+	scopes := []string{}
+
+	clusterDir := filepath.Join(outDir, "clusterscoped")
+	resources, err := manifestFiles(clusterDir)
+	if err != nil {
+		return err
+	}
+	if len(resources) > 0 {
+		if err := writeKustomization(w, clusterDir, newKustomization("", resources)); err != nil {
+			return err
+		}
+		scopes = append(scopes, "clusterscoped")
+	}
+
+	namespacedDir := filepath.Join(outDir, "namespaced")
+	namespaces, err := subdirs(namespacedDir)
+	if err != nil {
+		return err
+	}
+	for _, namespace := range namespaces {
+		namespaceDir := filepath.Join(namespacedDir, namespace)
+		resources, err := manifestFiles(namespaceDir)
+		if err != nil {
+			return err
+		}
+		if len(resources) == 0 {
+			continue
+		}
+		if err := writeKustomization(w, namespaceDir, newKustomization(namespace, resources)); err != nil {
+			return err
+		}
+		scopes = append(scopes, path.Join("namespaced", namespace))
+	}
+
+	return writeKustomization(w, outDir, newKustomization("", scopes))
+}
+
+func writeKustomization(w fileWriter, dir string, k kustomization) error {
+	content, err := yaml.Marshal(k)
+	if err != nil {
+		return fmt.Errorf("failed marshalling kustomization: %v", err)
+	}
+	return w.writeFile(filepath.Join(dir, kustomizationFilename), content)
+}
+
+// manifestFiles returns the sorted paths of the manifests in the resource directories of dir, relative to dir.
+func manifestFiles(dir string) ([]string, error) {
+	resources, err := subdirs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, resource := range resources {
+		entries, err := os.ReadDir(filepath.Join(dir, resource))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), ".yaml") {
+				files = append(files, path.Join(resource, entry.Name()))
+			}
+		}
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+// subdirs returns the names of the directories in dir, none when dir doesn't exist.
+func subdirs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs = append(dirs, entry.Name())
+		}
+	}
+	return dirs, nil
+}
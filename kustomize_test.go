@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteKustomizations(t *testing.T) {
+	outDir := t.TempDir()
+	w := fileWriter{fileMode: 0o600, dirMode: 0o700, uid: -1, gid: -1}
+
+	for _, filename := range []string{
+		"clusterscoped/namespaces/team-a.yaml",
+		"namespaced/team-a/pods/web.yaml",
+		"namespaced/team-a/configmaps/app.yaml",
+		"namespaced/team-a/pods/web.events.txt", // not a manifest
+		"namespaced/team-a/pods/web/web.log",    // not a manifest
+		"namespaced/empty/pods/web/web.log",     // no manifests
+		"report.json",
+	} {
+		if err := w.writeFile(filepath.Join(outDir, filename), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writeKustomizations(w, outDir); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{
+			filename: kustomizationFilename,
+			want:     "apiVersion: kustomize.config.k8s.io/v1beta1\nkind: Kustomization\nresources:\n- clusterscoped\n- namespaced/team-a\n",
+		},
+		{
+			filename: filepath.Join("clusterscoped", kustomizationFilename),
+			want:     "apiVersion: kustomize.config.k8s.io/v1beta1\nkind: Kustomization\nresources:\n- namespaces/team-a.yaml\n",
+		},
+		{
+			filename: filepath.Join("namespaced", "team-a", kustomizationFilename),
+			want:     "apiVersion: kustomize.config.k8s.io/v1beta1\nkind: Kustomization\nnamespace: team-a\nresources:\n- configmaps/app.yaml\n- pods/web.yaml\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			got, err := os.ReadFile(filepath.Join(outDir, tt.filename))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := os.Stat(filepath.Join(outDir, "namespaced", "empty", kustomizationFilename)); !os.IsNotExist(err) {
+		t.Errorf("got kustomization for namespace without manifests: %v", err)
+	}
+}
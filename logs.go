@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// logOptions are the settings for collecting container logs.
+type logOptions struct {
+	enabled    bool
+	previous   bool          // also collect the logs of the previous container instance
+	since      time.Duration // 0 for all
+	tail       uint64        // number of lines, 0 for all
+	limitBytes uint64        // 0 for no limit
+}
+
+// containerLog is a log of a container which can be collected.
+type containerLog struct {
+	container string
+	previous  bool
+}
+
+var podsGVR = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+// podLogs returns the logs of the pod's containers which exist: of containers which were started,
+// and of their previous instances when they were restarted and previous is set.
+func podLogs(item unstructured.Unstructured, previous bool) ([]containerLog, error) {
+	var pod corev1.Pod
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &pod); err != nil {
+		return nil, fmt.Errorf("failed converting pod: %v", err)
+	}
+
+	var statuses []corev1.ContainerStatus
+	statuses = append(statuses, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	statuses = append(statuses, pod.Status.EphemeralContainerStatuses...)
+
+	var logs []containerLog
+	for _, status := range statuses {
+		if status.State.Running != nil || status.State.Terminated != nil {
+			logs = append(logs, containerLog{container: status.Name})
+		}
+		if previous && status.LastTerminationState.Terminated != nil {
+			logs = append(logs, containerLog{container: status.Name, previous: true})
+		}
+	}
+
+	return logs, nil
+}
+
+// writeContainerLog fetches the log and writes it next to the manifest of the pod
+// as '<pod>/<container>.log' or '<pod>/<container>.previous.log' and returns the number of written bytes.
+func (d *dumper) writeContainerLog(ctx context.Context, podPath, namespace, pod string, cl containerLog) (int, error) {
+	logOpts := &corev1.PodLogOptions{
+		Container: cl.container,
+		Previous:  cl.previous,
+	}
+	if d.opts.logs.since > 0 {
+		sinceSeconds := int64(d.opts.logs.since.Seconds())
+		logOpts.SinceSeconds = &sinceSeconds
+	}
+	if d.opts.logs.tail > 0 {
+		tailLines := int64(d.opts.logs.tail)
+		logOpts.TailLines = &tailLines
+	}
+	if d.opts.logs.limitBytes > 0 {
+		limitBytes := int64(d.opts.logs.limitBytes)
+		logOpts.LimitBytes = &limitBytes
+	}
+
+	var content []byte
+	err := withRetry(ctx, d.opts.retry, func() error {
+		stream, err := d.clientset.CoreV1().Pods(namespace).GetLogs(pod, logOpts).Stream(ctx)
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+
+		content, err = io.ReadAll(stream)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	suffix := ".log"
+	if cl.previous {
+		suffix = ".previous.log"
+	}
+	filename := filepath.Join(podPath, cl.container+suffix)
+	if err := d.writer.writeFile(filename, content); err != nil {
+		return 0, err
+	}
+
+	return len(content), nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestPodLogs(t *testing.T) {
+	pod := unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   map[string]any{"name": "app", "namespace": "default"},
+		"status": map[string]any{
+			"initContainerStatuses": []any{
+				map[string]any{"name": "init", "state": map[string]any{"terminated": map[string]any{"exitCode": int64(0)}}},
+			},
+			"containerStatuses": []any{
+				map[string]any{"name": "running", "state": map[string]any{"running": map[string]any{}}},
+				map[string]any{
+					"name":         "restarted",
+					"state":        map[string]any{"running": map[string]any{}},
+					"lastState":    map[string]any{"terminated": map[string]any{"exitCode": int64(1)}},
+					"restartCount": int64(1),
+				},
+				map[string]any{"name": "waiting", "state": map[string]any{"waiting": map[string]any{"reason": "ContainerCreating"}}},
+			},
+		},
+	}}
+
+	tests := []struct {
+		name     string
+		previous bool
+		want     []containerLog
+	}{
+		{
+			name: "current",
+			want: []containerLog{{container: "init"}, {container: "running"}, {container: "restarted"}},
+		},
+		{
+			name:     "previous",
+			previous: true,
+			want:     []containerLog{{container: "init"}, {container: "running"}, {container: "restarted"}, {container: "restarted", previous: true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := podLogs(pod, tt.previous)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,25 +2,25 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"slices"
 	"strconv"
 	"strings"
-	"sync"
-	"sync/atomic"
+	"syscall"
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"sigs.k8s.io/yaml"
 )
 
@@ -31,6 +31,9 @@ var (
 	date    = "undefined"
 )
 
+// infoOutput receives the informational output, it's stderr with -print-report to keep stdout parseable.
+var infoOutput io.Writer = os.Stdout
+
 func lookupEnvString(key string, defaultVal string) string {
 	if val, ok := os.LookupEnv(key); ok {
 		return val
@@ -60,17 +63,43 @@ func lookupEnvUint64(key string, defaultVal uint64) uint64 {
 	return defaultVal
 }
 
-func main() {
-	start := time.Now()
+func lookupEnvFloat64(key string, defaultVal float64) float64 {
+	if val, ok := os.LookupEnv(key); ok {
+		parsed, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			log.Fatalf("failed parsing %q as float64 (%q): %v", val, key, err)
+		}
+		return parsed
+	}
+	return defaultVal
+}
 
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		log.Fatalf("failed getting user home dir: %v\n", err)
+func lookupEnvDuration(key string, defaultVal time.Duration) time.Duration {
+	if val, ok := os.LookupEnv(key); ok {
+		parsed, err := time.ParseDuration(val)
+		if err != nil {
+			log.Fatalf("failed parsing %q as duration (%q): %v", val, key, err)
+		}
+		return parsed
 	}
+	return defaultVal
+}
 
+func main() {
 	var (
-		kubeConfigPath       = flag.String("config", lookupEnvString("CONFIG", filepath.Join(homeDir, ".kube", "config")), "path to the kubeconfig, empty for in-cluster config")
+		kubeConfigPath       = flag.String("config", lookupEnvString("CONFIG", ""), "path to the kubeconfig, empty for $KUBECONFIG or ~/.kube/config (in-cluster config when none exists)")
 		kubeContext          = flag.String("context", lookupEnvString("CONTEXT", ""), "context from the kubeconfig, empty for default")
+		namespaceFlag        = flag.String("namespace", lookupEnvString("NAMESPACE", ""), "default namespace, overrides the one of the context")
+		serverFlag           = flag.String("server", lookupEnvString("SERVER", ""), "address of the API server, overrides the one of the kubeconfig")
+		tokenFlag            = flag.String("token", lookupEnvString("TOKEN", ""), "bearer token for authenticating to the API server")
+		tokenFileFlag        = flag.String("token-file", lookupEnvString("TOKEN_FILE", ""), "path to a file containing the bearer token for authenticating to the API server")
+		caFlag               = flag.String("certificate-authority", lookupEnvString("CERTIFICATE_AUTHORITY", ""), "path to a CA certificate file for verifying the API server")
+		insecureFlag         = flag.Bool("insecure-skip-tls-verify", lookupEnvBool("INSECURE_SKIP_TLS_VERIFY", false), "don't verify the certificate of the API server, this makes the connection insecure")
+		asFlag               = flag.String("as", lookupEnvString("AS", ""), "user to impersonate")
+		asGroupFlag          = flag.String("as-group", lookupEnvString("AS_GROUP", ""), "groups to impersonate (e.g. 'group1,group2')")
+		proxyURLFlag         = flag.String("proxy-url", lookupEnvString("PROXY_URL", ""), "URL of the proxy for connecting to the API server")
+		contextsFlag         = flag.String("contexts", lookupEnvString("CONTEXTS", ""), "dump the clusters of several contexts concurrently, each into a subdirectory (e.g. 'prod-*,staging')")
+		allContextsFlag      = flag.Bool("all-contexts", lookupEnvBool("ALL_CONTEXTS", false), "dump the clusters of all contexts from the kubeconfig concurrently, each into a subdirectory")
 		outdirFlag           = flag.String("dir", lookupEnvString("DIR", "dump"), "output directory for the dumps")
 		labelsFlag           = flag.String("labels", lookupEnvString("LABELS", ""), "dump resources with the given labels (e.g. key1=value1,key2=value2), empty for all")
 		ignoreLabelsFlag     = flag.String("ignore-labels", lookupEnvString("IGNORE_LABELS", ""), "ignore resources with the given labels (e.g. key1=value1,key2=value2)")
@@ -86,13 +115,86 @@ func main() {
 		versionFlag          = flag.Bool("version", lookupEnvBool("VERSION", false), fmt.Sprintf("print version information of this release (%v)", version))
 		maxThreadsFlag       = flag.Uint64("threads", lookupEnvUint64("THREADS", 10), "maximum number of threads (minimum 1)")
 		verbosityFlag        = flag.Uint64("verbosity", lookupEnvUint64("VERBOSITY", 1), "verbosity of the output (0-3)")
+		qpsFlag              = flag.Float64("qps", lookupEnvFloat64("QPS", 100), "maximum queries per second to the API server")
+		burstFlag            = flag.Uint64("burst", lookupEnvUint64("BURST", 300), "maximum burst of queries to the API server")
+		timeoutFlag          = flag.Duration("timeout", lookupEnvDuration("TIMEOUT", 0), "timeout of a single request to the API server (e.g. '2m'), including the whole List and log streams, 0 for no timeout")
+		retriesFlag          = flag.Uint64("retries", lookupEnvUint64("RETRIES", 5), "number of retries for transient errors (e.g. 429, 5xx, connection resets)")
+		retryBackoffFlag     = flag.Duration("retry-backoff", lookupEnvDuration("RETRY_BACKOFF", 1*time.Second), "initial backoff between retries, doubled after each retry")
+		reportFlag           = flag.Bool("report", lookupEnvBool("REPORT", true), fmt.Sprintf("write a machine-readable report of the dump to %q in the output directory", reportFilename))
+		printReportFlag      = flag.Bool("print-report", lookupEnvBool("PRINT_REPORT", false), "print the machine-readable report of the dump to stdout, the other output goes to stderr")
+		metricsAddrFlag      = flag.String("metrics-addr", lookupEnvString("METRICS_ADDR", ""), "address to serve the /metrics, /healthz and /readyz endpoints on (e.g. ':9090'), empty to disable, /readyz succeeds once the discovery of the first dump succeeded")
+		pushgatewayFlag      = flag.String("pushgateway", lookupEnvString("PUSHGATEWAY", ""), "URL of a Prometheus Pushgateway to push the metrics to at exit (e.g. 'http://pushgateway:9091'), empty to disable")
+		scheduleFlag         = flag.String("schedule", lookupEnvString("SCHEDULE", ""), "run repeatedly on the given cron schedule (e.g. '@every 1h', '0 3 * * *'), each run into a timestamped subdirectory, empty to run once")
+		keepLastFlag         = flag.Uint64("keep-last", lookupEnvUint64("KEEP_LAST", 0), "keep the last n scheduled dumps, 0 and no other keep flag to keep all")
+		keepDailyFlag        = flag.Uint64("keep-daily", lookupEnvUint64("KEEP_DAILY", 0), "keep the latest scheduled dump of each of the last n days")
+		keepWeeklyFlag       = flag.Uint64("keep-weekly", lookupEnvUint64("KEEP_WEEKLY", 0), "keep the latest scheduled dump of each of the last n weeks")
+		fileModeFlag         = flag.String("file-mode", lookupEnvString("FILE_MODE", "0600"), "permissions of the dumped files")
+		dirModeFlag          = flag.String("dir-mode", lookupEnvString("DIR_MODE", "0700"), "permissions of the created directories")
+		chownFlag            = flag.String("chown", lookupEnvString("CHOWN", ""), "change the owner of the dumped files and directories to 'uid:gid' or 'uid' (requires root), empty to keep")
+		atomicDirFlag        = flag.Bool("atomic-dir", lookupEnvBool("ATOMIC_DIR", false), fmt.Sprintf("build the dump in a staging directory and replace the output directory only when the dump succeeded, keeping the replaced one with the %q suffix", previousSuffix))
+		checkPermsFlag       = flag.Bool("check-permissions", lookupEnvBool("CHECK_PERMISSIONS", false), "print whether the selected resources can be listed cluster-wide and in each of the given namespaces, without dumping")
+		skipForbiddenFlag    = flag.Bool("skip-forbidden", lookupEnvBool("SKIP_FORBIDDEN", false), "check the permissions before listing a resource and skip it without an error when listing is forbidden")
+		logsFlag             = flag.Bool("logs", lookupEnvBool("LOGS", false), "collect the container logs of the dumped pods next to their manifests")
+		logsPreviousFlag     = flag.Bool("logs-previous", lookupEnvBool("LOGS_PREVIOUS", false), "also collect the logs of the previous instances of restarted containers")
+		logSinceFlag         = flag.Duration("log-since", lookupEnvDuration("LOG_SINCE", 0), "only collect log lines newer than the duration (e.g. '1h'), 0 for all")
+		logTailFlag          = flag.Uint64("log-tail", lookupEnvUint64("LOG_TAIL", 0), "only collect the last lines of each log, 0 for all")
+		logLimitBytesFlag    = flag.Uint64("log-limit-bytes", lookupEnvUint64("LOG_LIMIT_BYTES", 10*1024*1024), "maximum number of bytes collected of each log, 0 for no limit")
+		eventsFlag           = flag.Bool("events", lookupEnvBool("EVENTS", false), "write a chronological timeline of the events per namespace and per involved object, dumping events.k8s.io events instead of the duplicated core ones")
+		crdsFlag             = flag.Bool("crds", lookupEnvBool("CRDS", false), fmt.Sprintf("dump custom resources only in their storage version, together with their CRDs, and write an index of them to %q", crdIndexFilename))
+		helmReleasesFlag     = flag.Bool("helm-releases", lookupEnvBool("HELM_RELEASES", false), fmt.Sprintf("decode the Helm release secrets into '%v/<namespace>/<release>/<revision>/'", helmDir))
+		skipHelmSecretsFlag  = flag.Bool("skip-helm-secrets", lookupEnvBool("SKIP_HELM_SECRETS", false), "don't dump the raw Helm release secrets which were decoded with -helm-releases")
+		rbacNameFlag         = flag.String("rbac-name", lookupEnvString("RBAC_NAME", "kubedump"), "name of the ServiceAccount, roles and bindings generated by the rbac command")
+		rbacNamespaceFlag    = flag.String("rbac-namespace", lookupEnvString("RBAC_NAMESPACE", "kubedump"), "namespace of the ServiceAccount generated by the rbac command")
+		chartValuesFlag      = flag.Bool("chart-values", lookupEnvBool("CHART_VALUES", false), "lift the replicas, image tags and resource limits into the values.yaml of the charts written with '-layout helm'")
+		extractDataFlag      = flag.Bool("extract-data", lookupEnvBool("EXTRACT_DATA", false), fmt.Sprintf("write each key of the data of config maps and secrets to a file in the '<name>%v' directory next to the manifest", extractedDataSuffix))
+		keyOrderFlag         = flag.String("key-order", lookupEnvString("KEY_ORDER", keyOrderAlphabetical), fmt.Sprintf("order of the keys in the manifests (%v), kubernetes starts with apiVersion, kind, metadata, spec", strings.Join(keyOrderValues, "|")))
+		incrementalFlag      = flag.Bool("incremental", lookupEnvBool("INCREMENTAL", false), fmt.Sprintf("only write the objects which changed since the previous dump into the same directory, tracked in %q, and remove the ones of deleted objects", stateFilename))
+		checksumsFlag        = flag.Bool("checksums", lookupEnvBool("CHECKSUMS", false), fmt.Sprintf("write the SHA-256 checksums of the dumped files and the report to %q in the output directory", checksumsFilename))
+		signKeyFlag          = flag.String("sign-key", lookupEnvString("SIGN_KEY", ""), fmt.Sprintf("path to a PEM encoded ed25519 private key (PKCS #8) for signing the checksums to %q, implies -checksums", signatureFilename))
+		verifyKeyFlag        = flag.String("verify-key", lookupEnvString("VERIFY_KEY", ""), "path to a PEM encoded ed25519 public key for verifying the signature with the verify command")
+		dryRunFlag           = flag.Bool("dry-run", lookupEnvBool("DRY_RUN", false), "print the discovered resources and whether they would be dumped or why not, without dumping")
+		dryRunCountFlag      = flag.Bool("dry-run-count", lookupEnvBool("DRY_RUN_COUNT", false), "count the objects of the resources which would be dumped, before filtering them by labels and namespaces, implies -dry-run")
+		layoutFlag           = flag.String("layout", lookupEnvString("LAYOUT", layoutDefault), fmt.Sprintf("layout of the output directory (%v)", strings.Join(layoutValues, "|")))
+		failOnFlag           = flag.String("fail-on", lookupEnvString("FAIL_ON", failOnAny), fmt.Sprintf("which errors result in a non-zero exit code (%v): write only counts errors writing files, list only errors discovering and listing resources and collecting logs, not write errors", strings.Join(failOnValues, "|")))
 	)
-	flag.Parse()
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of kubedump:\n  kubedump [flags]                       dump the manifests\n  kubedump rbac [flags]                  print a ServiceAccount with the least privileges for dumping with the given flags\n  kubedump verify [flags] [dir|archive]  verify the checksums and the signature of a dump, the -dir by default\n\nFlags:\n")
+		flag.PrintDefaults()
+	}
+
+	command, args := parseCommand(os.Args[1:])
+	if err := flag.CommandLine.Parse(args); err != nil {
+		log.Fatalln(err) // not reached, the default flag set exits on errors
+	}
+	if command != "" && command != commandRBAC && command != commandVerify {
+		log.Fatalf("unknown command %q\n", command)
+	}
+	if command == commandVerify && flag.NArg() <= 1 {
+		target := *outdirFlag
+		if flag.NArg() == 1 {
+			target = flag.Arg(0)
+		}
+		if ok := verify(target, *verifyKeyFlag); !ok {
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.NArg() > 0 {
+		log.Fatalf("unexpected arguments %q, commands have to be given before the flags\n", flag.Args())
+	}
+
+	if *printReportFlag {
+		infoOutput = os.Stderr
+	}
 
 	if *versionFlag || *verbosityFlag > 1 {
-		fmt.Printf("version: %v\n", version)
-		fmt.Printf("commit: %v\n", commit)
-		fmt.Printf("date: %v\n", date)
+		out := infoOutput
+		if *versionFlag {
+			out = os.Stdout
+		}
+		fmt.Fprintf(out, "version: %v\n", version)
+		fmt.Fprintf(out, "commit: %v\n", commit)
+		fmt.Fprintf(out, "date: %v\n", date)
 
 		if *versionFlag {
 			os.Exit(0)
@@ -103,117 +205,314 @@ func main() {
 		log.Fatalln("minimum number of threads is 1")
 	}
 
-	kubeConfig, err := buildConfigFromFlags(*kubeContext, *kubeConfigPath)
-	if err != nil {
-		log.Fatalf("failed getting Kubernetes config: %v\n", err)
+	if *qpsFlag <= 0 {
+		log.Fatalln("qps has to be greater than 0")
+	}
+
+	if *burstFlag < 1 || *burstFlag > math.MaxInt32 {
+		log.Fatalf("burst has to be between 1 and %d\n", math.MaxInt32)
+	}
+
+	if !slices.Contains(layoutValues, *layoutFlag) {
+		log.Fatalf("invalid value %q for layout, valid values: %v\n", *layoutFlag, strings.Join(layoutValues, ", "))
+	}
+	if !slices.Contains(keyOrderValues, *keyOrderFlag) {
+		log.Fatalf("invalid value %q for key-order, valid values: %v\n", *keyOrderFlag, strings.Join(keyOrderValues, ", "))
+	}
+	if !slices.Contains(failOnValues, *failOnFlag) {
+		log.Fatalf("invalid value %q for fail-on, valid values: %v\n", *failOnFlag, strings.Join(failOnValues, ", "))
 	}
 
-	clientset, err := kubernetes.NewForConfig(kubeConfig)
+	fileMode, err := parseFileMode(*fileModeFlag)
 	if err != nil {
-		log.Fatalf("failed getting Kubernetes clientset: %v\n", err)
+		log.Fatalf("failed parsing file-mode: %v\n", err)
 	}
 
-	groups, err := clientset.DiscoveryClient.ServerGroups()
+	dirMode, err := parseFileMode(*dirModeFlag)
 	if err != nil {
-		log.Fatalf("failed getting server groups: %v\n", err)
+		log.Fatalf("failed parsing dir-mode: %v\n", err)
 	}
 
-	dynamicClient, err := dynamic.NewForConfig(kubeConfig)
+	uid, gid, err := parseOwner(*chownFlag)
 	if err != nil {
-		log.Fatalf("failed creating dynamic client: %v\n", err)
+		log.Fatalf("failed parsing chown: %v\n", err)
+	}
+
+	if *tokenFlag != "" && *tokenFileFlag != "" {
+		log.Fatalln("token can't be combined with token-file")
+	}
+
+	if *asGroupFlag != "" && *asFlag == "" {
+		log.Fatalln("as-group requires as")
+	}
+
+	if *chartValuesFlag && *layoutFlag != layoutHelm {
+		log.Fatalln("chart-values requires the helm layout")
+	}
+
+	// the charts and kustomizations would apply the manifests without the extracted keys
+	if *extractDataFlag && *layoutFlag != layoutDefault {
+		log.Fatalf("extract-data can't be combined with the %v layout\n", *layoutFlag)
+	}
+
+	if *kubeContext != "" && (*contextsFlag != "" || *allContextsFlag) {
+		log.Fatalln("context can't be combined with contexts or all-contexts")
+	}
+
+	if *incrementalFlag && (*atomicDirFlag || *scheduleFlag != "") {
+		log.Fatalln("incremental can't be combined with atomic-dir or schedule, they don't dump into the same directory")
+	}
+
+	var signKey ed25519.PrivateKey
+	if *signKeyFlag != "" {
+		content, err := os.ReadFile(*signKeyFlag)
+		if err != nil {
+			log.Fatalf("failed reading sign-key: %v\n", err)
+		}
+		if signKey, err = parseSigningKey(content); err != nil {
+			log.Fatalf("failed parsing sign-key: %v\n", err)
+		}
+	}
+
+	if *atomicDirFlag && !validAtomicDir(*outdirFlag) {
+		log.Fatalf("output directory %q can't be used with atomic-dir\n", *outdirFlag)
+	}
+
+	var sched schedule
+	if *scheduleFlag != "" {
+		sched, err = parseSchedule(*scheduleFlag)
+		if err != nil {
+			log.Fatalf("failed parsing schedule: %v\n", err)
+		}
+	}
+
+	promMetrics := newMetrics()
+	if *metricsAddrFlag != "" {
+		go func() {
+			if err := serveMetrics(context.Background(), *metricsAddrFlag, promMetrics); err != nil {
+				log.Fatalf("failed serving metrics: %v\n", err)
+			}
+		}()
 	}
 
 	var (
-		writtenFiles uint64
-		waitGroup    sync.WaitGroup
-		threadGuard  = make(chan struct{}, *maxThreadsFlag)
-
-		wantLabels       = parseLabelsFlag(*labelsFlag)
-		wantResources    = strings.Split(strings.ToLower(*resourcesFlag), ",")
-		wantNamespaces   = strings.Split(strings.ToLower(*namespacesFlag), ",")
-		wantGroups       = strings.Split(strings.ToLower(*groupsFlag), ",")
-		ignoreLabels     = parseLabelsFlag(*ignoreLabelsFlag)
-		ignoreResources  = strings.Split(strings.ToLower(*ignoreResourcesFlag), ",")
-		ignoreNamespaces = strings.Split(strings.ToLower(*ignoreNamespacesFlag), ",")
-		ignoreGroups     = strings.Split(strings.ToLower(*ignoreGroupsFlag), ",")
+		writer = fileWriter{fileMode: fileMode, dirMode: dirMode, uid: uid, gid: gid, keepUnchanged: *incrementalFlag}
+		client = clientOptions{
+			kubeconfigPath:        *kubeConfigPath,
+			namespace:             *namespaceFlag,
+			server:                *serverFlag,
+			token:                 *tokenFlag,
+			tokenFile:             *tokenFileFlag,
+			certificateAuthority:  *caFlag,
+			insecureSkipTLSVerify: *insecureFlag,
+			impersonate:           *asFlag,
+			impersonateGroups:     splitList(*asGroupFlag),
+			proxyURL:              *proxyURLFlag,
+			qps:                   float32(*qpsFlag),
+			burst:                 int(*burstFlag),
+			timeout:               *timeoutFlag,
+		}
+		opts = options{
+			threads:         *maxThreadsFlag,
+			verbosity:       *verbosityFlag,
+			stateless:       *statelessFlag,
+			namespaced:      *namespacedFlag,
+			clusterscoped:   *clusterscopedFlag,
+			retry:           retryPolicy{retries: *retriesFlag, backoff: *retryBackoffFlag},
+			failOn:          *failOnFlag,
+			report:          *reportFlag,
+			printReport:     *printReportFlag,
+			pushgateway:     *pushgatewayFlag,
+			atomicDir:       *atomicDirFlag,
+			skipForbidden:   *skipForbiddenFlag,
+			events:          *eventsFlag,
+			crds:            *crdsFlag,
+			layout:          *layoutFlag,
+			keyOrder:        *keyOrderFlag,
+			extractData:     *extractDataFlag,
+			chartValues:     *chartValuesFlag,
+			helmReleases:    *helmReleasesFlag,
+			skipHelmSecrets: *skipHelmSecretsFlag,
+			incremental:     *incrementalFlag,
+			checksums:       *checksumsFlag || *signKeyFlag != "",
+			dryRunCount:     *dryRunCountFlag,
+			signKey:         signKey,
+			logs: logOptions{
+				enabled:    *logsFlag || *logsPreviousFlag,
+				previous:   *logsPreviousFlag,
+				since:      *logSinceFlag,
+				tail:       *logTailFlag,
+				limitBytes: *logLimitBytesFlag,
+			},
+
+			wantLabels:       parseLabelsFlag(*labelsFlag),
+			wantResources:    strings.Split(strings.ToLower(*resourcesFlag), ","),
+			wantNamespaces:   strings.Split(strings.ToLower(*namespacesFlag), ","),
+			wantGroups:       strings.Split(strings.ToLower(*groupsFlag), ","),
+			ignoreLabels:     parseLabelsFlag(*ignoreLabelsFlag),
+			ignoreResources:  strings.Split(strings.ToLower(*ignoreResourcesFlag), ","),
+			ignoreNamespaces: strings.Split(strings.ToLower(*ignoreNamespacesFlag), ","),
+			ignoreGroups:     strings.Split(strings.ToLower(*ignoreGroupsFlag), ","),
+
+			filters: reportFilters{
+				Labels:           *labelsFlag,
+				IgnoreLabels:     *ignoreLabelsFlag,
+				Resources:        *resourcesFlag,
+				IgnoreResources:  *ignoreResourcesFlag,
+				Namespaces:       *namespacesFlag,
+				IgnoreNamespaces: *ignoreNamespacesFlag,
+				Groups:           *groupsFlag,
+				IgnoreGroups:     *ignoreGroupsFlag,
+				Clusterscoped:    *clusterscopedFlag,
+				Namespaced:       *namespacedFlag,
+				Stateless:        *statelessFlag,
+			},
+		}
 	)
 
-	for _, group := range groups.Groups {
-		if skipGroup(group, wantGroups, ignoreGroups) {
-			continue
-		}
+	stop, abort := notifyContexts()
+	if sched == nil {
+		// only scheduled runs wait for the abort context, end hanging requests on the second signal
+		go func() {
+			<-abort.Done()
+			os.Exit(130)
+		}()
+	}
 
-		for _, version := range group.Versions {
-			resources, err := clientset.DiscoveryClient.ServerResourcesForGroupVersion(version.GroupVersion)
+	var dump dumpFunc
+	if *contextsFlag != "" || *allContextsFlag {
+		m, err := newMultiDumper(*contextsFlag, *allContextsFlag, client, opts, writer, promMetrics)
+		if err != nil {
+			log.Fatalf("failed setting up clusters: %v\n", err)
+		}
+		if command == commandRBAC {
+			log.Fatalln("the rbac command can't be combined with contexts or all-contexts")
+		}
+		if *checkPermsFlag {
+			if failed := m.checkPermissions(stop, os.Stdout); failed {
+				os.Exit(1)
+			}
+			return
+		}
+		if *dryRunFlag || *dryRunCountFlag {
+			if failed := m.dryRun(stop, os.Stdout); failed {
+				os.Exit(1)
+			}
+			return
+		}
+		dump = func(ctx context.Context, outDir string) (bool, bool) {
+			combined, failed := m.dumpTo(ctx, outDir)
+			return combined.Complete, failed
+		}
+	} else {
+		d, err := newDumper(*kubeContext, client, opts, writer, promMetrics, log.Default())
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if command == commandRBAC {
+			manifests, err := d.rbacManifests(stop, *rbacNameFlag, *rbacNamespaceFlag)
 			if err != nil {
-				log.Printf("failed getting resources for %q: %v\n", version.GroupVersion, err)
-				continue
+				log.Fatalf("failed generating RBAC manifests: %v\n", err)
 			}
-
-			waitGroup.Add(len(resources.APIResources))
-			for _, res := range resources.APIResources {
-				threadGuard <- struct{}{} // would block if guard channel is already filled
-
-				go func(res metav1.APIResource, group metav1.APIGroup, version metav1.GroupVersionForDiscovery) {
-					defer func() {
-						waitGroup.Done()
-						<-threadGuard
-					}()
-
-					if skipResource(res, wantResources, ignoreResources) {
-						return
-					}
-
-					gvr := schema.GroupVersionResource{
-						Group:    group.Name,
-						Version:  version.Version,
-						Resource: res.Name,
-					}
-
-					if *verbosityFlag > 1 {
-						fmt.Printf("processing group=%v resource=%v\n", gvr.Group, gvr.Resource)
-					}
-
-					unstrList, err := dynamicClient.Resource(gvr).List(context.Background(), metav1.ListOptions{})
-					if err != nil {
-						log.Printf("failed listing %v: %v\n", gvr.String(), err)
-						return
-					}
-
-					for _, item := range unstrList.Items {
-						if skipItem(item, *namespacedFlag, *clusterscopedFlag, wantNamespaces, ignoreNamespaces) {
-							continue
-						}
-
-						if skipLabels(item.GetLabels(), wantLabels, ignoreLabels) {
-							continue
-						}
-
-						// Use a combination of resource and group name as it might not be unique otherwise.
-						// Example content of the variables:
-						//		resource: "pod"		group: ""
-						//		resource: "pod"		group: "metrics.k8s.io"
-						resourceAndGroup := strings.TrimSuffix(fmt.Sprintf("%s.%s", res.Name, group.Name), ".")
-
-						if *verbosityFlag > 2 {
-							fmt.Printf("processing manifest group=%v version=%v resource=%v namespace=%v name=%q\n", gvr.Group, gvr.Version, gvr.Resource, item.GetNamespace(), item.GetName())
-						}
-
-						if err := writeYAML(*outdirFlag, resourceAndGroup, item, *statelessFlag); err != nil {
-							log.Printf("failed writing %v/%v: %v\n", item.GetNamespace(), item.GetName(), err)
-							continue
-						}
-						atomic.AddUint64(&writtenFiles, 1)
-					}
-				}(res, group, version)
+			fmt.Print(string(manifests))
+			return
+		}
+		if *checkPermsFlag {
+			if err := d.checkPermissions(stop, os.Stdout); err != nil {
+				log.Fatalf("failed checking permissions: %v\n", err)
+			}
+			return
+		}
+		if *dryRunFlag || *dryRunCountFlag {
+			if err := d.dryRun(stop, os.Stdout); err != nil {
+				log.Fatalf("failed planning the dump: %v\n", err)
 			}
+			return
+		}
+		dump = func(ctx context.Context, outDir string) (bool, bool) {
+			dumpReport, failed := d.dumpTo(ctx, outDir)
+			return dumpReport.Complete, failed
 		}
 	}
 
-	waitGroup.Wait()
-	if *verbosityFlag > 0 {
-		fmt.Printf("loaded %d manifests in %v\n", writtenFiles, time.Since(start).Round(1*time.Millisecond))
+	if sched != nil {
+		// finish the current run on the first signal, but don't start a new one
+		runScheduled(stop, abort, dump, opts, writer, *outdirFlag, sched, retentionPolicy{
+			keepLast:   *keepLastFlag,
+			keepDaily:  *keepDailyFlag,
+			keepWeekly: *keepWeeklyFlag,
+		})
+		return
+	}
+
+	if _, failed := dump(stop, *outdirFlag); failed {
+		os.Exit(1)
+	}
+}
+
+const (
+	commandRBAC   = "rbac"
+	commandVerify = "verify"
+)
+
+// parseCommand splits the arguments into the command, empty for dumping, and the flags.
+func parseCommand(args []string) (string, []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "", args
+	}
+	return args[0], args[1:]
+}
+
+// verify verifies the dump in the directory or archive, with the public key when a path is given,
+// and returns whether it's intact.
+func verify(target, keyPath string) bool {
+	var key ed25519.PublicKey
+	if keyPath != "" {
+		content, err := os.ReadFile(keyPath)
+		if err != nil {
+			log.Fatalf("failed reading verify-key: %v\n", err)
+		}
+		if key, err = parseVerifyKey(content); err != nil {
+			log.Fatalf("failed parsing verify-key: %v\n", err)
+		}
+	}
+
+	ok, err := verifyDump(target, key, os.Stdout)
+	if err != nil {
+		log.Printf("failed verifying %q: %v\n", target, err)
+		return false
+	}
+	return ok
+}
+
+// notifyContexts returns a context which is done on the first SIGINT/SIGTERM
+// and a context which is done on the second one.
+func notifyContexts() (first, second context.Context) {
+	first, cancelFirst := context.WithCancel(context.Background())
+	second, cancelSecond := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		log.Printf("received %v, stopping\n", sig)
+		cancelFirst()
+
+		sig = <-signals
+		log.Printf("received %v, aborting\n", sig)
+		cancelSecond()
+	}()
+
+	return first, second
+}
+
+// splitList splits a comma separated list, empty for an empty string.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
 	}
+	return strings.Split(s, ",")
 }
 
 func parseLabelsFlag(labelsFlag string) map[string]string {
@@ -249,29 +548,53 @@ func skipGroup(group metav1.APIGroup, wantGroups, ignoreGroups []string) bool {
 	return false
 }
 
+// reasons why a group or resource is not dumped
+const (
+	skipReasonNoList         = "no list verb"
+	skipReasonSubresource    = "subresource"
+	skipReasonFiltered       = "filtered"
+	skipReasonForbidden      = "forbidden"
+	skipReasonDuplicate      = "duplicate"
+	skipReasonStorageVersion = "not storage version"
+)
+
+// values of the -layout flag
+const (
+	layoutDefault   = "default"
+	layoutKustomize = "kustomize"
+	layoutHelm      = "helm"
+)
+
+var layoutValues = []string{layoutDefault, layoutKustomize, layoutHelm}
+
 func skipResource(res metav1.APIResource, wantResources, ignoreResources []string) bool {
+	return skipResourceReason(res, wantResources, ignoreResources) != ""
+}
+
+// skipResourceReason returns why the resource should be skipped, empty if it should be dumped.
+func skipResourceReason(res metav1.APIResource, wantResources, ignoreResources []string) string {
 	// check if we can even 'list' the resource
 	if !slices.Contains(res.Verbs, "list") {
-		return true
+		return skipReasonNoList
 	}
 
 	// skip subresources
 	// TODO: maybe there is a better way to not get them in the first place
 	if strings.Contains(res.Name, "/") {
-		return true
+		return skipReasonSubresource
 	}
 
 	// check if we got the specified resources (if any resources were specified)
 	if len(wantResources) > 0 && wantResources[0] != "" && !slices.Contains(wantResources, res.Name) {
-		return true
+		return skipReasonFiltered
 	}
 
 	// check if we got a resource to ignore (if any resources were specified)
 	if len(ignoreResources) > 0 && ignoreResources[0] != "" && slices.Contains(ignoreResources, res.Name) {
-		return true
+		return skipReasonFiltered
 	}
 
-	return false
+	return ""
 }
 
 func skipItem(item unstructured.Unstructured, namespaced, clusterscoped bool, wantNamespaces, ignoreNamespaces []string) bool {
@@ -331,33 +654,38 @@ func skipLabelsIgnore(got, ignore map[string]string) bool {
 	return false
 }
 
-func writeYAML(outDir, resourceAndGroup string, item unstructured.Unstructured, stateless bool) error {
-	if stateless {
+// writeYAML writes the item to the manifest path with the '.yaml' extension and returns the number of written bytes.
+func writeYAML(w fileWriter, manifestPath string, item unstructured.Unstructured, opts options) (int, error) {
+	filename := manifestPath + ".yaml"
+
+	if opts.stateless {
 		cleanState(item)
 	}
-
-	yamlBytes, err := yaml.Marshal(item.Object)
-	if err != nil {
-		return fmt.Errorf("failed marshalling: %v", err)
+	if (opts.layout == layoutKustomize || opts.layout == layoutHelm) && item.GetNamespace() != "" {
+		// the namespace is set by the kustomization or the release, the caller still needs it
+		item = *item.DeepCopy()
+		item.SetNamespace("")
 	}
 
-	namespace := "clusterscoped"
-	if item.GetNamespace() != "" {
-		namespace = filepath.Join("namespaced", item.GetNamespace())
+	var yamlBytes []byte
+	var err error
+	if opts.keyOrder == keyOrderKubernetes {
+		yamlBytes, err = marshalOrdered(item.Object)
+	} else {
+		yamlBytes, err = yaml.Marshal(item.Object)
 	}
-
-	dir := filepath.Join(outDir, namespace, resourceAndGroup)
-	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
-		return fmt.Errorf("failed creating dir %q: %v", dir, err)
+	if err != nil {
+		return 0, fmt.Errorf("failed marshalling: %v", err)
+	}
+	if opts.layout == layoutHelm {
+		yamlBytes = helmTemplate(yamlBytes)
 	}
 
-	objName := strings.ReplaceAll(item.GetName(), ":", "_") // windows compatibility
-	filename := filepath.Join(dir, objName) + ".yaml"
-	if err = os.WriteFile(filename, yamlBytes, os.ModePerm); err != nil {
-		return fmt.Errorf("failed writing file %q: %v", filename, err)
+	if err = w.writeFile(filename, yamlBytes); err != nil {
+		return 0, err
 	}
 
-	return nil
+	return len(yamlBytes), nil
 }
 
 func cleanState(item unstructured.Unstructured) {
@@ -397,13 +725,37 @@ func cleanState(item unstructured.Unstructured) {
 	}
 }
 
+// clientConfig loads the kubeconfig like kubectl: the explicit path, otherwise the files of $KUBECONFIG merged,
+// otherwise ~/.kube/config. The in-cluster config is used when none of them exists.
+func clientConfig(context string, client clientOptions) clientcmd.ClientConfig {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = client.kubeconfigPath
+
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: context,
+		Context: clientcmdapi.Context{
+			Namespace: client.namespace,
+		},
+		ClusterInfo: clientcmdapi.Cluster{
+			Server:                client.server,
+			CertificateAuthority:  client.certificateAuthority,
+			InsecureSkipTLSVerify: client.insecureSkipTLSVerify,
+			ProxyURL:              client.proxyURL,
+		},
+		AuthInfo: clientcmdapi.AuthInfo{
+			Token:             client.token,
+			TokenFile:         client.tokenFile,
+			Impersonate:       client.impersonate,
+			ImpersonateGroups: client.impersonateGroups,
+		},
+	}
+
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+}
+
 // https://github.com/kubernetes/client-go/issues/192#issuecomment-349564767
-func buildConfigFromFlags(context, kubeconfigPath string) (*rest.Config, error) {
-	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfigPath},
-		&clientcmd.ConfigOverrides{
-			CurrentContext: context,
-		}).ClientConfig()
+func buildConfigFromFlags(context string, client clientOptions) (*rest.Config, error) {
+	config, err := clientConfig(context, client).ClientConfig()
 	if err != nil {
 		return config, err
 	}
@@ -411,7 +763,31 @@ func buildConfigFromFlags(context, kubeconfigPath string) (*rest.Config, error)
 	// https://kubernetes.io/blog/2020/09/03/warnings/#customize-client-handling
 	config = rest.CopyConfig(config)
 	config.WarningHandler = rest.NoWarnings{}
-	config.QPS = 100
-	config.Burst = 300
+	config.QPS = client.qps
+	config.Burst = client.burst
+	config.Timeout = client.timeout
 	return config, nil
 }
+
+// resolveContext returns the name of the used context, empty for the in-cluster config.
+func resolveContext(context string, client clientOptions) string {
+	if context != "" {
+		return context
+	}
+
+	rawConfig, err := clientConfig(context, client).RawConfig()
+	if err != nil {
+		return ""
+	}
+
+	return rawConfig.CurrentContext
+}
+
+// resolveNamespace returns the default namespace of the context, 'default' if it has none.
+func resolveNamespace(context string, client clientOptions) string {
+	namespace, _, err := clientConfig(context, client).Namespace()
+	if err != nil {
+		return metav1.NamespaceDefault
+	}
+	return namespace
+}
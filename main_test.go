@@ -1,6 +1,8 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -382,3 +384,149 @@ func TestSkipItem(t *testing.T) {
 		})
 	}
 }
+
+func TestSkipResourceReason(t *testing.T) {
+	tests := []struct {
+		name            string
+		res             metav1.APIResource
+		wantResources   []string
+		ignoreResources []string
+		want            string
+	}{
+		{
+			name: "no list verb",
+			res:  metav1.APIResource{Name: "myresource", Verbs: metav1.Verbs{"get"}},
+			want: skipReasonNoList,
+		},
+		{
+			name: "subresource",
+			res:  metav1.APIResource{Name: "resource/subresource", Verbs: metav1.Verbs{"list"}},
+			want: skipReasonSubresource,
+		},
+		{
+			name:          "not wanted",
+			res:           metav1.APIResource{Name: "myresource", Verbs: metav1.Verbs{"list"}},
+			wantResources: []string{"otherresource"},
+			want:          skipReasonFiltered,
+		},
+		{
+			name:            "ignored",
+			res:             metav1.APIResource{Name: "myresource", Verbs: metav1.Verbs{"list"}},
+			ignoreResources: []string{"myresource"},
+			want:            skipReasonFiltered,
+		},
+		{
+			name: "dumped",
+			res:  metav1.APIResource{Name: "myresource", Verbs: metav1.Verbs{"list"}},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := skipResourceReason(tt.res, tt.wantResources, tt.ignoreResources); got != tt.want {
+				t.Errorf("skipResourceReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildConfigFromFlags(t *testing.T) {
+	dir := t.TempDir()
+
+	writeKubeconfig := func(name, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	first := writeKubeconfig("first", `apiVersion: v1
+kind: Config
+current-context: one
+clusters:
+- name: one
+  cluster:
+    server: https://one.example.com
+contexts:
+- name: one
+  context:
+    cluster: one
+    namespace: team-a
+`)
+	second := writeKubeconfig("second", `apiVersion: v1
+kind: Config
+clusters:
+- name: two
+  cluster:
+    server: https://two.example.com
+contexts:
+- name: two
+  context:
+    cluster: two
+`)
+	t.Setenv("KUBECONFIG", first+string(filepath.ListSeparator)+second)
+
+	tests := []struct {
+		name            string
+		context         string
+		client          clientOptions
+		wantHost        string
+		wantToken       string
+		wantImpersonate string
+		wantNamespace   string
+	}{
+		{
+			name:          "merged current context",
+			wantHost:      "https://one.example.com",
+			wantNamespace: "team-a",
+		},
+		{
+			name:          "context from second file",
+			context:       "two",
+			wantHost:      "https://two.example.com",
+			wantNamespace: "default",
+		},
+		{
+			name:          "explicit path takes precedence",
+			context:       "two",
+			client:        clientOptions{kubeconfigPath: second},
+			wantHost:      "https://two.example.com",
+			wantNamespace: "default",
+		},
+		{
+			name:            "overrides",
+			client:          clientOptions{server: "https://override.example.com", token: "secret", impersonate: "jane", namespace: "team-b"},
+			wantHost:        "https://override.example.com",
+			wantToken:       "secret",
+			wantImpersonate: "jane",
+			wantNamespace:   "team-b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := buildConfigFromFlags(tt.context, tt.client)
+			if err != nil {
+				t.Fatalf("buildConfigFromFlags() error = %v", err)
+			}
+			if config.Host != tt.wantHost {
+				t.Errorf("got host %q, want %q", config.Host, tt.wantHost)
+			}
+			if config.BearerToken != tt.wantToken {
+				t.Errorf("got token %q, want %q", config.BearerToken, tt.wantToken)
+			}
+			if config.Impersonate.UserName != tt.wantImpersonate {
+				t.Errorf("got impersonated user %q, want %q", config.Impersonate.UserName, tt.wantImpersonate)
+			}
+			if got := resolveNamespace(tt.context, tt.client); got != tt.wantNamespace {
+				t.Errorf("got namespace %q, want %q", got, tt.wantNamespace)
+			}
+		})
+	}
+
+	// the explicit path doesn't merge with $KUBECONFIG
+	if _, err := buildConfigFromFlags("one", clientOptions{kubeconfigPath: second}); err == nil {
+		t.Errorf("expected an error for a context which is not in the explicit kubeconfig")
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// upper bounds of the list duration histogram buckets in seconds
+var listDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+type groupResource struct {
+	group    string
+	resource string
+}
+
+type errorKey struct {
+	phase    dumpPhase
+	category errorCategory
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(value float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(listDurationBuckets))
+	}
+	for i, bound := range listDurationBuckets {
+		if value <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// metrics holds the Prometheus metrics of all runs and is safe for concurrent use.
+type metrics struct {
+	mu sync.Mutex
+
+	ready    bool                       // the discovery of a dump succeeded once, it's never reset
+	clusters map[string]*clusterMetrics // by context
+}
+
+// clusterMetrics are the metrics of the runs of a single context.
+type clusterMetrics struct {
+	objects         map[groupResource]uint64
+	listDurations   map[groupResource]*histogram
+	errors          map[errorKey]uint64
+	bytesWritten    uint64
+	runs            map[bool]uint64 // by success
+	lastSuccess     time.Time
+	lastRunDuration time.Duration
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		clusters: make(map[string]*clusterMetrics),
+	}
+}
+
+// setReady marks the process as ready, /readyz doesn't tell whether the latest run succeeded, see kubedump_runs_total.
+func (m *metrics) setReady(ready bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ready = ready
+}
+
+func (m *metrics) isReady() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.ready
+}
+
+// observe records the outcome of a finished run of the context of the report.
+func (m *metrics) observe(r *report, errs *errorCollector, success bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r.mu.Lock()
+	c := m.clusters[r.Context]
+	if c == nil {
+		c = &clusterMetrics{
+			objects:       make(map[groupResource]uint64),
+			listDurations: make(map[groupResource]*histogram),
+			errors:        make(map[errorKey]uint64),
+			runs:          make(map[bool]uint64),
+		}
+		m.clusters[r.Context] = c
+	}
+	for _, res := range r.Resources {
+		key := groupResource{group: res.Group, resource: res.Resource}
+		c.objects[key] += uint64(res.Objects)
+		c.bytesWritten += uint64(res.Bytes)
+
+		if c.listDurations[key] == nil {
+			c.listDurations[key] = &histogram{}
+		}
+		c.listDurations[key].observe(res.ListDuration)
+	}
+	c.lastRunDuration = r.End.Sub(r.Start)
+	end := r.End
+	r.mu.Unlock()
+
+	for _, e := range errs.all() {
+		c.errors[errorKey{phase: e.Phase, category: e.Category}]++
+	}
+
+	c.runs[success]++
+	if success {
+		c.lastSuccess = end
+	}
+}
+
+// writeTo writes the metrics in the Prometheus text exposition format, labeled with the context.
+// Label values are escaped by labelValue.
+func (m *metrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	contexts := slices.Sorted(maps.Keys(m.clusters))
+	sortedGroupResources := func(keys []groupResource) []groupResource {
+		return slices.SortedFunc(slices.Values(keys), func(a, b groupResource) int {
+			return cmp.Or(cmp.Compare(a.group, b.group), cmp.Compare(a.resource, b.resource))
+		})
+	}
+
+	fmt.Fprintln(w, "# HELP kubedump_objects_dumped_total Number of dumped objects.")
+	fmt.Fprintln(w, "# TYPE kubedump_objects_dumped_total counter")
+	for _, context := range contexts {
+		c := m.clusters[context]
+		for _, key := range sortedGroupResources(slices.Collect(maps.Keys(c.objects))) {
+			fmt.Fprintf(w, "kubedump_objects_dumped_total{context=%v,group=%v,resource=%v} %d\n", labelValue(context), labelValue(key.group), labelValue(key.resource), c.objects[key])
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP kubedump_list_duration_seconds Duration of listing a resource, including retries.")
+	fmt.Fprintln(w, "# TYPE kubedump_list_duration_seconds histogram")
+	for _, context := range contexts {
+		c := m.clusters[context]
+		for _, key := range sortedGroupResources(slices.Collect(maps.Keys(c.listDurations))) {
+			h := c.listDurations[key]
+			labels := fmt.Sprintf("context=%v,group=%v,resource=%v", labelValue(context), labelValue(key.group), labelValue(key.resource))
+
+			var cumulative uint64
+			for i, bound := range listDurationBuckets {
+				cumulative += h.counts[i]
+				fmt.Fprintf(w, "kubedump_list_duration_seconds_bucket{%v,le=%v} %d\n", labels, labelValue(formatFloat(bound)), cumulative)
+			}
+			fmt.Fprintf(w, "kubedump_list_duration_seconds_bucket{%v,le=\"+Inf\"} %d\n", labels, h.count)
+			fmt.Fprintf(w, "kubedump_list_duration_seconds_sum{%v} %v\n", labels, formatFloat(h.sum))
+			fmt.Fprintf(w, "kubedump_list_duration_seconds_count{%v} %d\n", labels, h.count)
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP kubedump_errors_total Number of errors by phase and category.")
+	fmt.Fprintln(w, "# TYPE kubedump_errors_total counter")
+	for _, context := range contexts {
+		c := m.clusters[context]
+		errorKeys := slices.SortedFunc(maps.Keys(c.errors), func(a, b errorKey) int {
+			return cmp.Or(cmp.Compare(a.phase, b.phase), cmp.Compare(a.category, b.category))
+		})
+		for _, key := range errorKeys {
+			fmt.Fprintf(w, "kubedump_errors_total{context=%v,phase=%v,category=%v} %d\n", labelValue(context), labelValue(string(key.phase)), labelValue(string(key.category)), c.errors[key])
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP kubedump_bytes_written_total Number of bytes written to manifests.")
+	fmt.Fprintln(w, "# TYPE kubedump_bytes_written_total counter")
+	for _, context := range contexts {
+		fmt.Fprintf(w, "kubedump_bytes_written_total{context=%v} %d\n", labelValue(context), m.clusters[context].bytesWritten)
+	}
+
+	fmt.Fprintln(w, "# HELP kubedump_runs_total Number of finished runs by result.")
+	fmt.Fprintln(w, "# TYPE kubedump_runs_total counter")
+	for _, context := range contexts {
+		c := m.clusters[context]
+		fmt.Fprintf(w, "kubedump_runs_total{context=%v,result=\"failure\"} %d\n", labelValue(context), c.runs[false])
+		fmt.Fprintf(w, "kubedump_runs_total{context=%v,result=\"success\"} %d\n", labelValue(context), c.runs[true])
+	}
+
+	fmt.Fprintln(w, "# HELP kubedump_last_run_duration_seconds Duration of the last run.")
+	fmt.Fprintln(w, "# TYPE kubedump_last_run_duration_seconds gauge")
+	for _, context := range contexts {
+		fmt.Fprintf(w, "kubedump_last_run_duration_seconds{context=%v} %v\n", labelValue(context), formatFloat(m.clusters[context].lastRunDuration.Seconds()))
+	}
+
+	fmt.Fprintln(w, "# HELP kubedump_last_success_timestamp_seconds Unix timestamp of the last successful run.")
+	fmt.Fprintln(w, "# TYPE kubedump_last_success_timestamp_seconds gauge")
+	for _, context := range contexts {
+		var lastSuccess int64
+		if c := m.clusters[context]; !c.lastSuccess.IsZero() {
+			lastSuccess = c.lastSuccess.Unix()
+		}
+		fmt.Fprintf(w, "kubedump_last_success_timestamp_seconds{context=%v} %d\n", labelValue(context), lastSuccess)
+	}
+}
+
+// serveMetrics serves the metrics and health endpoints until the context is done.
+func serveMetrics(ctx context.Context, addr string, m *metrics) error {
+	server := &http.Server{Addr: addr, Handler: metricsHandler(m), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		if err := server.Shutdown(context.Background()); err != nil {
+			log.Printf("failed shutting down metrics server: %v\n", err)
+		}
+	}()
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func metricsHandler(m *metrics) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.writeTo(w)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !m.isReady() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+// pushMetrics replaces the metrics of the kubedump job on the given Pushgateway.
+func pushMetrics(pushgatewayURL string, m *metrics) error {
+	var body bytes.Buffer
+	m.writeTo(&body)
+
+	url := strings.TrimSuffix(pushgatewayURL, "/") + "/metrics/job/kubedump"
+	req, err := http.NewRequest(http.MethodPut, url, &body)
+	if err != nil {
+		return fmt.Errorf("failed creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed pushing metrics: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed pushing metrics: %v: %s", resp.Status, respBody)
+	}
+
+	return nil
+}
+
+// labelEscaper escapes label values as required by the text exposition format, which only knows these escapes.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelValue returns the quoted and escaped label value.
+func labelValue(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
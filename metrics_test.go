@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testMetrics() *metrics {
+	start := time.Unix(1700000000, 0)
+
+	r := &report{Context: "prod", Start: start}
+	r.addResource(reportResource{Group: "apps", Version: "v1", Resource: "deployments", Objects: 2, Bytes: 200, ListDuration: 0.3})
+	r.addResource(reportResource{Version: "v1", Resource: "configmaps", Objects: 1, Bytes: 100, ListDuration: 7})
+
+	var errs errorCollector
+	errs.add(phaseList, "v1/secrets", "", errors.New("boom"))
+	r.finish(&errs)
+	r.End = start.Add(10 * time.Second)
+
+	// a failed run of another cluster
+	other := &report{Context: "staging", Start: start}
+	other.addResource(reportResource{Version: "v1", Resource: "configmaps", Objects: 3, Bytes: 50, ListDuration: 0.1})
+	other.finish(&errorCollector{})
+	other.End = start.Add(5 * time.Second)
+
+	m := newMetrics()
+	m.observe(r, &errs, true)
+	m.observe(other, &errorCollector{}, false)
+	return m
+}
+
+func TestMetricsWriteTo(t *testing.T) {
+	var buf bytes.Buffer
+	testMetrics().writeTo(&buf)
+	got := buf.String()
+
+	for _, want := range []string{
+		`kubedump_objects_dumped_total{context="prod",group="apps",resource="deployments"} 2`,
+		`kubedump_objects_dumped_total{context="prod",group="",resource="configmaps"} 1`,
+		`kubedump_objects_dumped_total{context="staging",group="",resource="configmaps"} 3`,
+		`kubedump_list_duration_seconds_bucket{context="prod",group="apps",resource="deployments",le="0.25"} 0`,
+		`kubedump_list_duration_seconds_bucket{context="prod",group="apps",resource="deployments",le="0.5"} 1`,
+		`kubedump_list_duration_seconds_bucket{context="prod",group="",resource="configmaps",le="5"} 0`,
+		`kubedump_list_duration_seconds_bucket{context="prod",group="",resource="configmaps",le="10"} 1`,
+		`kubedump_list_duration_seconds_bucket{context="prod",group="",resource="configmaps",le="+Inf"} 1`,
+		`kubedump_list_duration_seconds_count{context="prod",group="",resource="configmaps"} 1`,
+		`kubedump_list_duration_seconds_count{context="staging",group="",resource="configmaps"} 1`,
+		`kubedump_errors_total{context="prod",phase="list",category="other"} 1`,
+		`kubedump_bytes_written_total{context="prod"} 300`,
+		`kubedump_bytes_written_total{context="staging"} 50`,
+		`kubedump_runs_total{context="prod",result="success"} 1`,
+		`kubedump_runs_total{context="staging",result="failure"} 1`,
+		`kubedump_runs_total{context="staging",result="success"} 0`,
+		`kubedump_last_run_duration_seconds{context="prod"} 10`,
+		`kubedump_last_run_duration_seconds{context="staging"} 5`,
+		`kubedump_last_success_timestamp_seconds{context="prod"} 1700000010`,
+		`kubedump_last_success_timestamp_seconds{context="staging"} 0`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("missing %q in:\n%v", want, got)
+		}
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "prod", want: `"prod"`},
+		{value: `a\b`, want: `"a\\b"`},
+		{value: `say "hi"`, want: `"say \"hi\""`},
+		{value: "a\nb", want: `"a\nb"`},
+		{value: "a\tb", want: "\"a\tb\""},
+		{value: "ü", want: `"ü"`},
+	}
+	for _, tt := range tests {
+		if got := labelValue(tt.value); got != tt.want {
+			t.Errorf("labelValue(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestPushMetrics(t *testing.T) {
+	var (
+		gotMethod string
+		gotPath   string
+		gotBody   string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotMethod, gotPath, gotBody = r.Method, r.URL.Path, string(body)
+	}))
+	defer server.Close()
+
+	if err := pushMetrics(server.URL+"/", testMetrics()); err != nil {
+		t.Fatalf("pushMetrics() error = %v", err)
+	}
+
+	if gotMethod != http.MethodPut {
+		t.Errorf("got method %v, want %v", gotMethod, http.MethodPut)
+	}
+	if gotPath != "/metrics/job/kubedump" {
+		t.Errorf("got path %v, want /metrics/job/kubedump", gotPath)
+	}
+	if !strings.Contains(gotBody, `kubedump_bytes_written_total{context="prod"} 300`+"\n") {
+		t.Errorf("pushed body misses metrics:\n%v", gotBody)
+	}
+}
+
+func TestPushMetricsError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid metrics", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	if err := pushMetrics(server.URL, newMetrics()); err == nil {
+		t.Error("pushMetrics() expected error")
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	m := newMetrics()
+	handler := metricsHandler(m)
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	if code := get("/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz = %v, want %v", code, http.StatusOK)
+	}
+	if code := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz before ready = %v, want %v", code, http.StatusServiceUnavailable)
+	}
+
+	m.setReady(true)
+	if code := get("/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz = %v, want %v", code, http.StatusOK)
+	}
+	if code := get("/metrics"); code != http.StatusOK {
+		t.Errorf("/metrics = %v, want %v", code, http.StatusOK)
+	}
+}
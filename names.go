@@ -0,0 +1,164 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	namesIndexFilename = "names.yaml"
+
+	// maxNameLength leaves room for the suffixes of the files of an object (e.g. '.events.txt')
+	// within the common limit of 255 bytes per filename.
+	maxNameLength  = 200
+	nameHashLength = 16
+	// nameHashSeparator separates the hash suffix, it's escaped in names.
+	nameHashSeparator = "~"
+)
+
+// windowsReservedNames can't be used as filenames on Windows, also not with an extension.
+var windowsReservedNames = []string{
+	"CON", "PRN", "AUX", "NUL",
+	"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+	"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9",
+}
+
+// escapeName escapes the bytes of the name which are unsafe in filenames on common filesystems
+// as '%XX', like in URLs, which is reversible with url.PathUnescape. Only letters, digits, '-', '_'
+// and '.' are kept, a trailing '.' and the first letter of reserved Windows names are escaped as well.
+func escapeName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		safe := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.'
+		// Windows strips trailing dots, and '.' and '..' are no valid names
+		if c == '.' && i == len(name)-1 {
+			safe = false
+		}
+		if safe {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	escaped := b.String()
+
+	base, _, _ := strings.Cut(escaped, ".")
+	if slices.Contains(windowsReservedNames, strings.ToUpper(base)) {
+		escaped = fmt.Sprintf("%%%02X", escaped[0]) + escaped[1:]
+	}
+	return escaped
+}
+
+// hashName shortens the escaped name, if necessary, and appends a hash of the original name,
+// which makes it unique. It isn't reversible.
+func hashName(escaped, name string) string {
+	sum := sha256.Sum256([]byte(name))
+	hash := hex.EncodeToString(sum[:])[:nameHashLength]
+
+	maxLength := maxNameLength - len(nameHashSeparator) - nameHashLength
+	if len(escaped) > maxLength {
+		escaped = escaped[:maxLength]
+		// don't cut an escape sequence
+		if i := strings.LastIndex(escaped, "%"); i >= len(escaped)-2 {
+			escaped = escaped[:i]
+		}
+	}
+	return escaped + nameHashSeparator + hash
+}
+
+// objectIdentity identifies an object regardless of its version.
+type objectIdentity struct {
+	ResourceAndGroup string `json:"resource"`
+	Namespace        string `json:"namespace,omitempty"`
+	Name             string `json:"name"`
+}
+
+// namesIndexEntry maps the path of an object whose name was changed to the object.
+type namesIndexEntry struct {
+	Path string `json:"path"` // relative to the output directory, without extension
+	objectIdentity
+}
+
+// namer assigns the paths of the objects in the output directory. Names which are too long get a hash suffix,
+// as well as names whose path collides with the path of another object on case-insensitive filesystems.
+type namer struct {
+	layout string
+	mu     sync.Mutex
+	paths  map[objectIdentity]string // relative to the output directory, without extension
+	taken  map[string]objectIdentity // lower-case paths
+}
+
+func newNamer(layout string) *namer {
+	return &namer{
+		layout: layout,
+		paths:  make(map[objectIdentity]string),
+		taken:  make(map[string]objectIdentity),
+	}
+}
+
+// objectPath returns the path of an object in the output directory, without a file extension.
+// With the helm layout, the objects are in the templates directory of the chart.
+func (n *namer) objectPath(outDir, resourceAndGroup, namespace, name string) string {
+	id := objectIdentity{ResourceAndGroup: resourceAndGroup, Namespace: namespace, Name: name}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if path, ok := n.paths[id]; ok {
+		return filepath.Join(outDir, path)
+	}
+
+	scope := "clusterscoped"
+	if namespace != "" {
+		scope = filepath.Join("namespaced", namespace)
+	}
+	if n.layout == layoutHelm {
+		scope = filepath.Join(scope, chartTemplatesDir)
+	}
+	dir := filepath.Join(scope, resourceAndGroup)
+
+	filename := escapeName(name)
+	if len(filename) > maxNameLength {
+		filename = hashName(filename, name)
+	}
+	if other, ok := n.taken[strings.ToLower(filepath.Join(dir, filename))]; ok && other != id {
+		filename = hashName(escapeName(name), name)
+	}
+
+	path := filepath.Join(dir, filename)
+	n.paths[id] = path
+	n.taken[strings.ToLower(path)] = id
+	return filepath.Join(outDir, path)
+}
+
+// writeIndex writes the index of the objects whose names were changed, if any.
+func (n *namer) writeIndex(w fileWriter, outDir string) error {
+	n.mu.Lock()
+	var entries []namesIndexEntry
+	for id, path := range n.paths {
+		if filepath.Base(path) != id.Name {
+			entries = append(entries, namesIndexEntry{Path: filepath.ToSlash(path), objectIdentity: id})
+		}
+	}
+	n.mu.Unlock()
+
+	if len(entries) == 0 {
+		return nil
+	}
+	slices.SortFunc(entries, func(a, b namesIndexEntry) int { return cmp.Compare(a.Path, b.Path) })
+
+	content, err := yaml.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("failed marshalling names index: %v", err)
+	}
+	return w.writeFile(filepath.Join(outDir, namesIndexFilename), content)
+}
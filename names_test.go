@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEscapeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "web-1.example_com", want: "web-1.example_com"},
+		{name: "system:controller:job", want: "system%3Acontroller%3Ajob"},
+		{name: "a<b>c|d?e*f\"g\\h/i", want: "a%3Cb%3Ec%7Cd%3Fe%2Af%22g%5Ch%2Fi"},
+		{name: "100%", want: "100%25"},
+		{name: "~hash", want: "%7Ehash"},
+		{name: "ü", want: "%C3%BC"},
+		{name: "trailing.", want: "trailing%2E"},
+		{name: ".", want: "%2E"},
+		{name: "..", want: ".%2E"},
+		{name: "con", want: "%63on"},
+		{name: "Aux.yaml", want: "%41ux.yaml"},
+		{name: "console", want: "console"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := escapeName(tt.name)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+
+			unescaped, err := url.PathUnescape(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if unescaped != tt.name {
+				t.Errorf("got unescaped %q, want %q", unescaped, tt.name)
+			}
+		})
+	}
+}
+
+func TestNamer(t *testing.T) {
+	outDir := t.TempDir()
+	n := newNamer(layoutDefault)
+
+	rel := func(path string) string {
+		t.Helper()
+		rel, err := filepath.Rel(outDir, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return filepath.ToSlash(rel)
+	}
+
+	if got, want := rel(n.objectPath(outDir, "pods", "default", "web")), "namespaced/default/pods/web"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// collisions on case-insensitive filesystems
+	first := rel(n.objectPath(outDir, "clusterroles.rbac.authorization.k8s.io", "", "view"))
+	second := rel(n.objectPath(outDir, "clusterroles.rbac.authorization.k8s.io", "", "View"))
+	if first != "clusterscoped/clusterroles.rbac.authorization.k8s.io/view" {
+		t.Errorf("got %q for the first name", first)
+	}
+	if !strings.HasPrefix(second, "clusterscoped/clusterroles.rbac.authorization.k8s.io/View~") {
+		t.Errorf("got %q for the colliding name", second)
+	}
+
+	// the same object gets the same path
+	if again := rel(n.objectPath(outDir, "clusterroles.rbac.authorization.k8s.io", "", "View")); again != second {
+		t.Errorf("got %q for the same object, want %q", again, second)
+	}
+
+	// too long names
+	long := strings.Repeat("a", 300)
+	longPath := n.objectPath(outDir, "configmaps", "default", long)
+	if base := filepath.Base(longPath); len(base) > maxNameLength || !strings.Contains(base, nameHashSeparator) {
+		t.Errorf("got %q (%d bytes) for the long name", base, len(base))
+	}
+	if other := n.objectPath(outDir, "configmaps", "default", long+"b"); other == longPath {
+		t.Errorf("got the same path for different long names")
+	}
+
+	w := fileWriter{fileMode: 0o600, dirMode: 0o700, uid: -1, gid: -1}
+	if err := n.writeIndex(w, outDir); err != nil {
+		t.Fatal(err)
+	}
+	index, err := os.ReadFile(filepath.Join(outDir, namesIndexFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"path: " + second + "\n", "name: View\n", "name: " + long + "\n"} {
+		if !strings.Contains(string(index), want) {
+			t.Errorf("index doesn't contain %q:\n%s", want, index)
+		}
+	}
+	if strings.Contains(string(index), "name: web\n") {
+		t.Errorf("index contains unchanged name:\n%s", index)
+	}
+}
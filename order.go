@@ -0,0 +1,86 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+
+	yamlv2 "go.yaml.in/yaml/v2"
+)
+
+// values of the -key-order flag
+const (
+	keyOrderAlphabetical = "alphabetical"
+	keyOrderKubernetes   = "kubernetes"
+)
+
+var keyOrderValues = []string{keyOrderAlphabetical, keyOrderKubernetes}
+
+// The keys which are written first, in the given order. The remaining keys follow alphabetically.
+var (
+	topLevelOrder  = []string{"apiVersion", "kind", "metadata", "spec", "type", "data", "stringData", "binaryData", "rules", "roleRef", "subjects"}
+	metadataOrder  = []string{"name", "generateName", "namespace", "labels", "annotations"}
+	containerOrder = []string{"name", "image", "imagePullPolicy", "command", "args", "workingDir", "ports", "env", "envFrom", "resources", "volumeMounts"}
+	listItemOrder  = []string{"name"}
+	lastKeys       = []string{"status"} // at the top level
+)
+
+// containerLists are the keys of the lists containing containers.
+var containerLists = []string{"containers", "initContainers", "ephemeralContainers"}
+
+// marshalOrdered marshals the object like yaml.Marshal, but with the keys in the conventional order
+// of Kubernetes manifests: apiVersion, kind, metadata, spec, ... and the remaining keys alphabetically.
+func marshalOrdered(obj map[string]any) ([]byte, error) {
+	return yamlv2.Marshal(orderMap(obj, topLevelOrder, lastKeys))
+}
+
+// orderMap returns the map as a slice with the first keys in the given order,
+// the remaining ones alphabetically and the last keys at the end.
+func orderMap(m map[string]any, first, last []string) yamlv2.MapSlice {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	rank := func(key string) int {
+		if i := slices.Index(first, key); i >= 0 {
+			return i - len(first) // negative, before the remaining keys
+		}
+		if slices.Contains(last, key) {
+			return 1
+		}
+		return 0
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return cmp.Or(cmp.Compare(rank(a), rank(b)), cmp.Compare(a, b))
+	})
+
+	ordered := make(yamlv2.MapSlice, 0, len(keys))
+	for _, key := range keys {
+		ordered = append(ordered, yamlv2.MapItem{Key: key, Value: orderValue(m[key], key, false)})
+	}
+	return ordered
+}
+
+// orderValue orders the maps of the value, key is the key of the value or of the list containing it.
+func orderValue(value any, key string, listItem bool) any {
+	switch v := value.(type) {
+	case map[string]any:
+		var first []string
+		switch {
+		case key == "metadata" && !listItem:
+			first = metadataOrder
+		case listItem && slices.Contains(containerLists, key):
+			first = containerOrder
+		case listItem:
+			first = listItemOrder
+		}
+		return orderMap(v, first, nil)
+	case []any:
+		items := make([]any, len(v))
+		for i, item := range v {
+			items[i] = orderValue(item, key, true)
+		}
+		return items
+	default:
+		return value
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func TestMarshalOrdered(t *testing.T) {
+	obj := map[string]any{
+		"status":     map[string]any{"phase": "Running"},
+		"spec":       map[string]any{"replicas": int64(2), "containers": []any{map[string]any{"resources": map[string]any{}, "image": "nginx", "name": "web"}}, "ports": []any{map[string]any{"port": int64(80), "name": "http"}}},
+		"metadata":   map[string]any{"labels": map[string]any{"b": "2", "a": "1"}, "namespace": "default", "name": "web", "uid": "123"},
+		"kind":       "Pod",
+		"apiVersion": "v1",
+		"data":       map[string]any{"multi": "line\n", "ratio": 0.5, "enabled": true, "empty": nil},
+	}
+
+	got, err := marshalOrdered(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `apiVersion: v1
+kind: Pod
+metadata:
+  name: web
+  namespace: default
+  labels:
+    a: "1"
+    b: "2"
+  uid: "123"
+spec:
+  containers:
+  - name: web
+    image: nginx
+    resources: {}
+  ports:
+  - name: http
+    port: 80
+  replicas: 2
+data:
+  empty: null
+  enabled: true
+  multi: |
+    line
+  ratio: 0.5
+status:
+  phase: Running
+`
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// only the order differs from yaml.Marshal
+	var gotObj, wantObj map[string]any
+	if err := yaml.Unmarshal(got, &gotObj); err != nil {
+		t.Fatal(err)
+	}
+	alphabetical, err := yaml.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal(alphabetical, &wantObj); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotObj, wantObj) {
+		t.Errorf("got object %v, want %v", gotObj, wantObj)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// rbacManifests discovers the resources selected by the filters and returns the manifests of a ServiceAccount
+// with the least privileges to dump them.
+func (d *dumper) rbacManifests(ctx context.Context, name, namespace string) ([]byte, error) {
+	resources, err := d.listedResources(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := rbacObjects(resources, name, namespace, d.selectedNamespaces(), d.opts.logs.enabled)
+	return marshalManifests(objects)
+}
+
+// listedResources returns the resources a dump lists: the discovered ones and the CRDs for -crds.
+func (d *dumper) listedResources(ctx context.Context) ([]apiResource, error) {
+	// errors of single group versions are logged by discover
+	resources, err := d.discover(ctx, &report{}, &errorCollector{})
+	if err != nil {
+		return nil, err
+	}
+
+	// the CRDs are listed regardless of the filters
+	if d.listsCRDs() && !slices.ContainsFunc(resources, func(res apiResource) bool { return res.gvr == crdsGVR }) {
+		resources = append(resources, apiResource{gvr: crdsGVR})
+	}
+	return resources, nil
+}
+
+// rbacObjects returns a ServiceAccount with the permissions to list the resources, which are already
+// filtered by their scope (see listsScope). Cluster-scoped resources are granted by a ClusterRole,
+// namespaced resources as well when no namespaces are given, otherwise by a Role in each namespace.
+// With logs, getting the logs of the pods is granted as well.
+func rbacObjects(resources []apiResource, name, namespace string, namespaces []string, logs bool) []runtime.Object {
+	var (
+		labels  = map[string]string{"app": "kubedump"}
+		subject = rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: name, Namespace: namespace}
+		objects = []runtime.Object{
+			&corev1.ServiceAccount{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+			},
+		}
+	)
+
+	var clusterGranted, namespaceGranted []apiResource
+	for _, res := range resources {
+		if res.namespaced && len(namespaces) > 0 {
+			namespaceGranted = append(namespaceGranted, res)
+		} else {
+			clusterGranted = append(clusterGranted, res)
+		}
+	}
+
+	if len(clusterGranted) > 0 {
+		objects = append(objects,
+			&rbacv1.ClusterRole{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+				ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+				Rules:      listRules(clusterGranted, logs),
+			},
+			&rbacv1.ClusterRoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+				ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name},
+				Subjects:   []rbacv1.Subject{subject},
+			},
+		)
+	}
+
+	if len(namespaceGranted) == 0 {
+		return objects
+	}
+
+	rules := listRules(namespaceGranted, logs)
+	for _, ns := range namespaces {
+		objects = append(objects,
+			&rbacv1.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"},
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns, Labels: labels},
+				Rules:      rules,
+			},
+			&rbacv1.RoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns, Labels: labels},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: name},
+				Subjects:   []rbacv1.Subject{subject},
+			},
+		)
+	}
+
+	return objects
+}
+
+// listRules returns a rule per group, granting to list the resources of the group,
+// and a rule for getting the logs of the pods when logs is set.
+func listRules(resources []apiResource, logs bool) []rbacv1.PolicyRule {
+	var (
+		byGroup = make(map[string][]string)
+		pods    bool
+	)
+	for _, res := range resources {
+		pods = pods || res.gvr == podsGVR
+		// the same resource might be served in several versions
+		if !slices.Contains(byGroup[res.gvr.Group], res.gvr.Resource) {
+			byGroup[res.gvr.Group] = append(byGroup[res.gvr.Group], res.gvr.Resource)
+		}
+	}
+
+	var rules []rbacv1.PolicyRule
+	for group, names := range byGroup {
+		slices.Sort(names)
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{group},
+			Resources: names,
+			Verbs:     []string{"list"},
+		})
+	}
+	slices.SortFunc(rules, func(a, b rbacv1.PolicyRule) int {
+		return cmp.Compare(a.APIGroups[0], b.APIGroups[0])
+	})
+
+	if logs && pods {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{""},
+			Resources: []string{"pods/log"},
+			Verbs:     []string{"get"},
+		})
+	}
+	return rules
+}
+
+// marshalManifests returns the objects as a multi-document YAML.
+func marshalManifests(objects []runtime.Object) ([]byte, error) {
+	var buf bytes.Buffer
+	for i, obj := range objects {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return nil, fmt.Errorf("failed converting %T: %v", obj, err)
+		}
+		unstructured.RemoveNestedField(content, "metadata", "creationTimestamp")
+
+		yamlBytes, err := yaml.Marshal(content)
+		if err != nil {
+			return nil, fmt.Errorf("failed marshalling %T: %v", obj, err)
+		}
+
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(yamlBytes)
+	}
+	return buf.Bytes(), nil
+}
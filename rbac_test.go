@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestRBACObjects(t *testing.T) {
+	namespaces := apiResource{gvr: schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}}
+	namespaced := []apiResource{
+		{gvr: schema.GroupVersionResource{Version: "v1", Resource: "pods"}, namespaced: true},
+		{gvr: schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}, namespaced: true},
+		{gvr: schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}, namespaced: true},
+		{gvr: schema.GroupVersionResource{Group: "example.com", Version: "v1beta1", Resource: "widgets"}, namespaced: true},
+	}
+	all := append([]apiResource{namespaces}, namespaced...)
+
+	tests := []struct {
+		name               string
+		resources          []apiResource
+		namespaces         []string
+		logs               bool
+		wantKinds          []string
+		wantClusterRules   []rbacv1.PolicyRule
+		wantNamespaceRules []rbacv1.PolicyRule
+	}{
+		{
+			name:      "cluster-wide",
+			resources: all,
+			wantKinds: []string{"ServiceAccount", "ClusterRole", "ClusterRoleBinding"},
+			wantClusterRules: []rbacv1.PolicyRule{
+				{APIGroups: []string{""}, Resources: []string{"configmaps", "namespaces", "pods"}, Verbs: []string{"list"}},
+				{APIGroups: []string{"example.com"}, Resources: []string{"widgets"}, Verbs: []string{"list"}},
+			},
+		},
+		{
+			name:      "cluster-scoped only",
+			resources: []apiResource{namespaces},
+			wantKinds: []string{"ServiceAccount", "ClusterRole", "ClusterRoleBinding"},
+			wantClusterRules: []rbacv1.PolicyRule{
+				{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"list"}},
+			},
+		},
+		{
+			name:       "namespaces",
+			resources:  namespaced,
+			namespaces: []string{"team-a", "team-b"},
+			wantKinds:  []string{"ServiceAccount", "Role", "RoleBinding", "Role", "RoleBinding"},
+			wantNamespaceRules: []rbacv1.PolicyRule{
+				{APIGroups: []string{""}, Resources: []string{"configmaps", "pods"}, Verbs: []string{"list"}},
+				{APIGroups: []string{"example.com"}, Resources: []string{"widgets"}, Verbs: []string{"list"}},
+			},
+		},
+		{
+			// all given resources are granted, cluster-scoped ones cluster-wide
+			name:       "namespaces with cluster-scoped",
+			resources:  all,
+			namespaces: []string{"team-a"},
+			wantKinds:  []string{"ServiceAccount", "ClusterRole", "ClusterRoleBinding", "Role", "RoleBinding"},
+			wantClusterRules: []rbacv1.PolicyRule{
+				{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"list"}},
+			},
+			wantNamespaceRules: []rbacv1.PolicyRule{
+				{APIGroups: []string{""}, Resources: []string{"configmaps", "pods"}, Verbs: []string{"list"}},
+				{APIGroups: []string{"example.com"}, Resources: []string{"widgets"}, Verbs: []string{"list"}},
+			},
+		},
+		{
+			name:       "logs",
+			resources:  namespaced,
+			namespaces: []string{"team-a"},
+			logs:       true,
+			wantKinds:  []string{"ServiceAccount", "Role", "RoleBinding"},
+			wantNamespaceRules: []rbacv1.PolicyRule{
+				{APIGroups: []string{""}, Resources: []string{"configmaps", "pods"}, Verbs: []string{"list"}},
+				{APIGroups: []string{"example.com"}, Resources: []string{"widgets"}, Verbs: []string{"list"}},
+				{APIGroups: []string{""}, Resources: []string{"pods/log"}, Verbs: []string{"get"}},
+			},
+		},
+		{
+			name:      "logs without pods",
+			resources: []apiResource{namespaces},
+			logs:      true,
+			wantKinds: []string{"ServiceAccount", "ClusterRole", "ClusterRoleBinding"},
+			wantClusterRules: []rbacv1.PolicyRule{
+				{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"list"}},
+			},
+		},
+		{
+			name:      "nothing",
+			wantKinds: []string{"ServiceAccount"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := rbacObjects(tt.resources, "kubedump", "backup", tt.namespaces, tt.logs)
+
+			var (
+				gotKinds          []string
+				gotClusterRules   []rbacv1.PolicyRule
+				gotNamespaceRules []rbacv1.PolicyRule
+			)
+			for _, obj := range objects {
+				gotKinds = append(gotKinds, obj.GetObjectKind().GroupVersionKind().Kind)
+
+				switch o := obj.(type) {
+				case *rbacv1.ClusterRole:
+					gotClusterRules = o.Rules
+				case *rbacv1.Role:
+					gotNamespaceRules = o.Rules
+					if o.Namespace == "" {
+						t.Errorf("role without namespace")
+					}
+				case *rbacv1.ClusterRoleBinding:
+					if o.Subjects[0].Namespace != "backup" {
+						t.Errorf("got subject namespace %q, want %q", o.Subjects[0].Namespace, "backup")
+					}
+				}
+			}
+
+			if !reflect.DeepEqual(gotKinds, tt.wantKinds) {
+				t.Errorf("got kinds %v, want %v", gotKinds, tt.wantKinds)
+			}
+			if !reflect.DeepEqual(gotClusterRules, tt.wantClusterRules) {
+				t.Errorf("got cluster rules %v, want %v", gotClusterRules, tt.wantClusterRules)
+			}
+			if !reflect.DeepEqual(gotNamespaceRules, tt.wantNamespaceRules) {
+				t.Errorf("got namespace rules %v, want %v", gotNamespaceRules, tt.wantNamespaceRules)
+			}
+		})
+	}
+}
+
+// TestRBACObjectsCoverRun dumps with exactly the permissions of the generated RBAC objects,
+// which have to grant all resources the dump lists and nothing else.
+func TestRBACObjectsCoverRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(opts *options)
+	}{
+		{
+			name: "cluster",
+		},
+		{
+			name: "namespaces",
+			modify: func(opts *options) {
+				opts.wantNamespaces = []string{"team-a", "team-b"}
+				opts.crds = true
+			},
+		},
+		{
+			name: "cluster-scoped only",
+			modify: func(opts *options) {
+				opts.namespaced = false
+			},
+		},
+		{
+			name: "namespaced only",
+			modify: func(opts *options) {
+				opts.clusterscoped = false
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions()
+			if tt.modify != nil {
+				tt.modify(&opts)
+			}
+
+			resources, err := newTestDumper(opts, allowAll).listedResources(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			objects := rbacObjects(resources, "kubedump", "backup", opts.wantNamespaces, false)
+
+			// the granted resources, cluster-wide by the empty namespace
+			granted := make(map[string]map[string]bool)
+			grant := func(namespace string, rules []rbacv1.PolicyRule) {
+				for _, rule := range rules {
+					for _, resource := range rule.Resources {
+						if granted[namespace] == nil {
+							granted[namespace] = make(map[string]bool)
+						}
+						granted[namespace][rule.APIGroups[0]+"/"+resource] = true
+					}
+				}
+			}
+			for _, obj := range objects {
+				switch o := obj.(type) {
+				case *rbacv1.ClusterRole:
+					grant("", o.Rules)
+				case *rbacv1.Role:
+					grant(o.Namespace, o.Rules)
+				}
+			}
+
+			var wantListed []string
+			for _, resources := range granted {
+				for resource := range resources {
+					if !slices.Contains(wantListed, resource) {
+						wantListed = append(wantListed, resource)
+					}
+				}
+			}
+			slices.Sort(wantListed)
+			if len(wantListed) == 0 {
+				t.Fatal("nothing granted")
+			}
+
+			resourceGroup := make(map[string]string)
+			for _, res := range resources {
+				resourceGroup[res.gvr.Resource] = res.gvr.Group
+			}
+			d := newTestDumper(opts, func(resource, namespace string) bool {
+				key := resourceGroup[resource] + "/" + resource
+				return granted[""][key] || namespace != "" && granted[namespace][key]
+			})
+			dumpReport, errs := d.run(context.Background(), t.TempDir())
+			if got := errs.all(); len(got) != 0 {
+				t.Fatalf("got errors %v", got)
+			}
+
+			var gotListed []string
+			for _, res := range dumpReport.Resources {
+				gotListed = append(gotListed, res.Group+"/"+res.Resource)
+			}
+			slices.Sort(gotListed)
+			if !slices.Equal(gotListed, wantListed) {
+				t.Errorf("got listed %v, want the granted %v", gotListed, wantListed)
+			}
+		})
+	}
+}
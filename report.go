@@ -0,0 +1,183 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const reportFilename = "report.json"
+
+// incompleteMarker exists in the output directory while a dump is running or when it was interrupted.
+const incompleteMarker = ".incomplete"
+
+// failedMarker exists in the directory of a scheduled dump which failed according to the -fail-on flag.
+const failedMarker = ".failed"
+
+// report is the machine-readable summary of a dump.
+type report struct {
+	mu sync.Mutex
+
+	Version       string           `json:"version"`
+	Commit        string           `json:"commit"`
+	Server        string           `json:"server"`
+	ServerVersion string           `json:"serverVersion"`
+	Context       string           `json:"context"`
+	Namespace     string           `json:"namespace"` // default namespace of the context
+	Start         time.Time        `json:"start"`
+	End           time.Time        `json:"end"`
+	Complete      bool             `json:"complete"` // false if the dump was interrupted or the discovery failed
+	Filters       reportFilters    `json:"filters"`
+	Objects       uint64           `json:"objects"`
+	Bytes         uint64           `json:"bytes"`
+	Unchanged     uint64           `json:"unchanged"` // objects not rewritten by an incremental dump
+	Removed       uint64           `json:"removed"`   // manifests of deleted objects removed by an incremental dump
+	Logs          uint64           `json:"logs"`      // number of collected container logs
+	LogBytes      uint64           `json:"logBytes"`  // not included in bytes
+	Resources     []reportResource `json:"resources"`
+	Skipped       []reportSkipped  `json:"skipped"`
+	Errors        []reportError    `json:"errors"`
+}
+
+type reportFilters struct {
+	Labels           string `json:"labels"`
+	IgnoreLabels     string `json:"ignoreLabels"`
+	Resources        string `json:"resources"`
+	IgnoreResources  string `json:"ignoreResources"`
+	Namespaces       string `json:"namespaces"`
+	IgnoreNamespaces string `json:"ignoreNamespaces"`
+	Groups           string `json:"groups"`
+	IgnoreGroups     string `json:"ignoreGroups"`
+	Clusterscoped    bool   `json:"clusterscoped"`
+	Namespaced       bool   `json:"namespaced"`
+	Stateless        bool   `json:"stateless"`
+}
+
+type reportResource struct {
+	Group        string   `json:"group"`
+	Version      string   `json:"version"`
+	Resource     string   `json:"resource"`
+	Listed       int      `json:"listed"`    // objects returned by the API server
+	Objects      int      `json:"objects"`   // objects dumped after filtering
+	Unchanged    int      `json:"unchanged"` // dumped objects which weren't rewritten as unchanged, with -incremental
+	Bytes        int      `json:"bytes"`
+	ListDuration float64  `json:"listDurationSeconds"`
+	Namespaces   []string `json:"namespaces,omitempty"` // listed one by one as listing cluster-wide is forbidden
+}
+
+type reportSkipped struct {
+	Group    string `json:"group"`
+	Version  string `json:"version,omitempty"`
+	Resource string `json:"resource,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+type reportError struct {
+	Phase    dumpPhase     `json:"phase"`
+	Category errorCategory `json:"category"`
+	Resource string        `json:"resource"`
+	Object   string        `json:"object,omitempty"`
+	Error    string        `json:"error"`
+}
+
+func (r *report) addResource(res reportResource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Resources = append(r.Resources, res)
+	r.Objects += uint64(res.Objects)
+	r.Unchanged += uint64(res.Unchanged)
+	r.Bytes += uint64(res.Bytes)
+}
+
+func (r *report) addLog(bytes int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Logs++
+	r.LogBytes += uint64(bytes)
+}
+
+func (r *report) addSkipped(gvr schema.GroupVersionResource, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Skipped = append(r.Skipped, reportSkipped{
+		Group:    gvr.Group,
+		Version:  gvr.Version,
+		Resource: gvr.Resource,
+		Reason:   reason,
+	})
+}
+
+// finish completes the report with the end time and the collected errors.
+func (r *report) finish(errs *errorCollector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.End = time.Now()
+
+	for _, e := range errs.all() {
+		r.Errors = append(r.Errors, reportError{
+			Phase:    e.Phase,
+			Category: e.Category,
+			Resource: e.Resource,
+			Object:   e.Object,
+			Error:    e.Err.Error(),
+		})
+	}
+
+	// the resources are processed concurrently, sort them for a stable output
+	slices.SortFunc(r.Resources, func(a, b reportResource) int {
+		return cmp.Or(cmp.Compare(a.Group, b.Group), cmp.Compare(a.Version, b.Version), cmp.Compare(a.Resource, b.Resource))
+	})
+	slices.SortFunc(r.Skipped, func(a, b reportSkipped) int {
+		return cmp.Or(cmp.Compare(a.Group, b.Group), cmp.Compare(a.Version, b.Version), cmp.Compare(a.Resource, b.Resource))
+	})
+}
+
+func (r *report) marshal() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// reportMarshaler is implemented by the reports of a single and of multiple clusters.
+type reportMarshaler interface {
+	marshal() ([]byte, error)
+}
+
+func writeReport(w fileWriter, outDir string, r reportMarshaler) error {
+	reportBytes, err := r.marshal()
+	if err != nil {
+		return fmt.Errorf("failed marshalling report: %v", err)
+	}
+
+	return w.writeFile(filepath.Join(outDir, reportFilename), reportBytes)
+}
+
+func writeIncompleteMarker(w fileWriter, outDir string) error {
+	content := fmt.Sprintf("dump started at %v\n", time.Now().Format(time.RFC3339))
+	return w.writeFile(filepath.Join(outDir, incompleteMarker), []byte(content))
+}
+
+func writeFailedMarker(w fileWriter, outDir string) error {
+	content := fmt.Sprintf("dump failed at %v\n", time.Now().Format(time.RFC3339))
+	return w.writeFile(filepath.Join(outDir, failedMarker), []byte(content))
+}
+
+func removeIncompleteMarker(outDir string) error {
+	err := os.Remove(filepath.Join(outDir, incompleteMarker))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
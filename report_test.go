@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestWriteReport(t *testing.T) {
+	outDir := t.TempDir()
+
+	r := &report{Version: "v1.2.3", Context: "mycontext"}
+	r.addResource(reportResource{Group: "apps", Version: "v1", Resource: "deployments", Listed: 3, Objects: 2, Bytes: 200})
+	r.addResource(reportResource{Version: "v1", Resource: "configmaps", Listed: 1, Objects: 1, Bytes: 100})
+	r.addSkipped(schema.GroupVersionResource{Version: "v1", Resource: "bindings"}, skipReasonNoList)
+
+	var errs errorCollector
+	errs.add(phaseList, "v1/secrets", "", errors.New("boom"))
+	r.finish(&errs)
+
+	if err := writeReport(fileWriter{fileMode: 0o600, dirMode: 0o700, uid: -1, gid: -1}, outDir, r); err != nil {
+		t.Fatalf("writeReport() error = %v", err)
+	}
+
+	reportBytes, err := os.ReadFile(filepath.Join(outDir, reportFilename))
+	if err != nil {
+		t.Fatalf("failed reading report: %v", err)
+	}
+
+	var got report
+	if err := json.Unmarshal(reportBytes, &got); err != nil {
+		t.Fatalf("failed unmarshalling report: %v", err)
+	}
+
+	if got.Objects != 3 || got.Bytes != 300 {
+		t.Errorf("got objects = %v, bytes = %v, want 3, 300", got.Objects, got.Bytes)
+	}
+
+	wantResources := []string{"configmaps", "deployments"}
+	var gotResources []string
+	for _, res := range got.Resources {
+		gotResources = append(gotResources, res.Resource)
+	}
+	if !reflect.DeepEqual(gotResources, wantResources) {
+		t.Errorf("got resources = %v, want %v", gotResources, wantResources)
+	}
+
+	wantErrors := []reportError{{Phase: phaseList, Category: categoryOther, Resource: "v1/secrets", Error: "boom"}}
+	if !reflect.DeepEqual(got.Errors, wantErrors) {
+		t.Errorf("got errors = %#v, want %#v", got.Errors, wantErrors)
+	}
+
+	wantSkipped := []reportSkipped{{Version: "v1", Resource: "bindings", Reason: skipReasonNoList}}
+	if !reflect.DeepEqual(got.Skipped, wantSkipped) {
+		t.Errorf("got skipped = %#v, want %#v", got.Skipped, wantSkipped)
+	}
+}
+
+func TestFinishReportError(t *testing.T) {
+	// the report can't be written into a file
+	outDir := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(outDir, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &dumper{
+		opts:    options{report: true, failOn: failOnWrite},
+		metrics: newMetrics(),
+		writer:  fileWriter{fileMode: 0o600, dirMode: 0o700, uid: -1, gid: -1},
+		log:     log.New(io.Discard, "", 0),
+	}
+	errs := &errorCollector{}
+	if failed := d.finish(outDir, &report{Complete: true}, errs); !failed {
+		t.Errorf("got failed = false, want true")
+	}
+	if got := errs.all(); len(got) != 1 || got[0].Phase != phaseWrite || got[0].Resource != reportFilename {
+		t.Errorf("got errors %v, want a write error of the report", got)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+type retentionPolicy struct {
+	keepLast   uint64
+	keepDaily  uint64
+	keepWeekly uint64
+}
+
+func (p retentionPolicy) keepAll() bool {
+	return p.keepLast == 0 && p.keepDaily == 0 && p.keepWeekly == 0
+}
+
+// selectSnapshots returns the snapshots to keep according to the policy.
+// A snapshot is kept when it is one of the last n snapshots, or the latest snapshot
+// of one of the last n days or weeks which have a snapshot. The newest snapshot is always kept.
+func selectSnapshots(snapshots []time.Time, policy retentionPolicy) map[time.Time]bool {
+	keep := make(map[time.Time]bool)
+	if policy.keepAll() {
+		for _, s := range snapshots {
+			keep[s] = true
+		}
+		return keep
+	}
+
+	newestFirst := slices.SortedFunc(slices.Values(snapshots), func(a, b time.Time) int {
+		return b.Compare(a)
+	})
+	if len(newestFirst) > 0 {
+		keep[newestFirst[0]] = true
+	}
+
+	var (
+		days  = make(map[string]bool)
+		weeks = make(map[string]bool)
+	)
+	for i, s := range newestFirst {
+		if uint64(i) < policy.keepLast {
+			keep[s] = true
+		}
+
+		day := s.Format(time.DateOnly)
+		if !days[day] && uint64(len(days)) < policy.keepDaily {
+			days[day] = true
+			keep[s] = true
+		}
+
+		year, week := s.ISOWeek()
+		weekKey := fmt.Sprintf("%d-%d", year, week)
+		if !weeks[weekKey] && uint64(len(weeks)) < policy.keepWeekly {
+			weeks[weekKey] = true
+			keep[s] = true
+		}
+	}
+
+	return keep
+}
+
+// applyRetention removes the snapshot directories in outDir which are not kept by the policy.
+// Only successful snapshots count for the policy. Failed and incomplete snapshots are removed,
+// except the newest snapshot, which is kept for inspection.
+func applyRetention(outDir string, policy retentionPolicy, verbosity uint64) error {
+	if policy.keepAll() {
+		return nil
+	}
+
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		return fmt.Errorf("failed reading dir %q: %v", outDir, err)
+	}
+
+	var (
+		snapshots  = make(map[time.Time]string)
+		successful []time.Time
+		newest     time.Time
+	)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		t, err := time.Parse(snapshotFormat, entry.Name())
+		if err != nil {
+			continue // not a snapshot
+		}
+		snapshots[t] = entry.Name()
+		if t.After(newest) {
+			newest = t
+		}
+		if successfulSnapshot(filepath.Join(outDir, entry.Name())) {
+			successful = append(successful, t)
+		}
+	}
+
+	keep := selectSnapshots(successful, policy)
+	keep[newest] = true
+	for t, name := range snapshots {
+		if keep[t] {
+			continue
+		}
+
+		if verbosity > 1 {
+			fmt.Fprintf(infoOutput, "removing expired dump %q\n", name)
+		}
+		if err := os.RemoveAll(filepath.Join(outDir, name)); err != nil {
+			return fmt.Errorf("failed removing %q: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// successfulSnapshot reports whether the snapshot in dir has neither an incomplete nor a failed marker.
+func successfulSnapshot(dir string) bool {
+	for _, marker := range []string{incompleteMarker, failedMarker} {
+		if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+			return false
+		}
+	}
+	return true
+}
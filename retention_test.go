@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSelectSnapshots(t *testing.T) {
+	snapshot := func(day, hour int) time.Time {
+		return time.Date(2024, time.January, day, hour, 0, 0, 0, time.UTC)
+	}
+
+	// 2024-01-01 is a monday
+	snapshots := []time.Time{
+		snapshot(1, 10), snapshot(1, 12),
+		snapshot(2, 12),
+		snapshot(8, 10), snapshot(8, 12),
+		snapshot(9, 10), snapshot(9, 12),
+	}
+
+	tests := []struct {
+		name   string
+		policy retentionPolicy
+		want   []time.Time
+	}{
+		{
+			name:   "keep all",
+			policy: retentionPolicy{},
+			want:   snapshots,
+		},
+		{
+			name:   "keep last",
+			policy: retentionPolicy{keepLast: 2},
+			want:   []time.Time{snapshot(9, 10), snapshot(9, 12)},
+		},
+		{
+			name:   "keep daily",
+			policy: retentionPolicy{keepDaily: 3},
+			want:   []time.Time{snapshot(2, 12), snapshot(8, 12), snapshot(9, 12)},
+		},
+		{
+			name:   "keep weekly",
+			policy: retentionPolicy{keepWeekly: 5},
+			want:   []time.Time{snapshot(2, 12), snapshot(9, 12)},
+		},
+		{
+			name:   "combined",
+			policy: retentionPolicy{keepLast: 1, keepDaily: 2, keepWeekly: 2},
+			want:   []time.Time{snapshot(2, 12), snapshot(8, 12), snapshot(9, 12)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keep := selectSnapshots(snapshots, tt.policy)
+
+			var got []time.Time
+			for _, s := range snapshots {
+				if keep[s] {
+					got = append(got, s)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("selectSnapshots() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyRetention(t *testing.T) {
+	outDir := t.TempDir()
+
+	for _, name := range []string{"2024-01-01T00-00-00Z", "2024-01-02T00-00-00Z", "2024-01-03T00-00-00Z", "other"} {
+		if err := os.Mkdir(filepath.Join(outDir, name), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := applyRetention(outDir, retentionPolicy{keepLast: 2}, 0); err != nil {
+		t.Fatalf("applyRetention() error = %v", err)
+	}
+
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.Name())
+	}
+
+	want := []string{"2024-01-02T00-00-00Z", "2024-01-03T00-00-00Z", "other"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got dirs %v, want %v", got, want)
+	}
+}
+
+func TestApplyRetentionFailed(t *testing.T) {
+	outDir := t.TempDir()
+
+	snapshots := map[string]string{
+		"2024-01-01T00-00-00Z": "",
+		"2024-01-02T00-00-00Z": failedMarker,
+		"2024-01-03T00-00-00Z": "",
+		"2024-01-04T00-00-00Z": incompleteMarker,
+		"2024-01-05T00-00-00Z": failedMarker,
+	}
+	for name, marker := range snapshots {
+		if err := os.Mkdir(filepath.Join(outDir, name), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if marker == "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(outDir, name, marker), nil, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := applyRetention(outDir, retentionPolicy{keepLast: 1}, 0); err != nil {
+		t.Fatalf("applyRetention() error = %v", err)
+	}
+
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.Name())
+	}
+
+	// the newest successful snapshot and the newest failed one
+	want := []string{"2024-01-03T00-00-00Z", "2024-01-05T00-00-00Z"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got dirs %v, want %v", got, want)
+	}
+}
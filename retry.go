@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// maxRetryBackoff caps the exponential backoff between two attempts.
+// A longer delay requested by the server with Retry-After is still respected.
+const maxRetryBackoff = 1 * time.Minute
+
+type retryPolicy struct {
+	retries uint64        // number of retries after the first attempt
+	backoff time.Duration // initial backoff, doubled after each attempt
+}
+
+// withRetry calls fn until it succeeds, returns a non-transient error,
+// the retries are exhausted or the context is done.
+func withRetry(ctx context.Context, policy retryPolicy, fn func() error) error {
+	var err error
+	for attempt := uint64(0); ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= policy.retries || !isRetryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(retryDelay(policy.backoff, attempt, err))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// retryDelay returns how long to wait before the next attempt.
+func retryDelay(backoff time.Duration, attempt uint64, err error) time.Duration {
+	delay := backoff
+	for i := uint64(0); i < attempt && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxRetryBackoff)
+
+	// respect the Retry-After header sent by the API server
+	if seconds, ok := apierrors.SuggestsClientDelay(err); ok {
+		delay = max(delay, time.Duration(seconds)*time.Second)
+	}
+
+	return delay
+}
+
+// isRetryable reports whether err is likely transient and the request worth retrying.
+func isRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServerTimeout(err) ||
+		apierrors.IsTimeout(err) ||
+		apierrors.IsInternalError(err) ||
+		apierrors.IsServiceUnavailable(err) ||
+		apierrors.IsUnexpectedServerError(err) {
+		return true
+	}
+
+	var status apierrors.APIStatus
+	if errors.As(err, &status) && status.Status().Code >= 500 {
+		return true
+	}
+
+	if utilnet.IsConnectionReset(err) ||
+		utilnet.IsConnectionRefused(err) ||
+		utilnet.IsHTTP2ConnectionLost(err) ||
+		utilnet.IsProbableEOF(err) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	// etcd errors are passed through by the API server with a generic status
+	msg := err.Error()
+	return strings.Contains(msg, "etcdserver: request timed out") ||
+		strings.Contains(msg, "etcdserver: leader changed")
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestIsRetryable(t *testing.T) {
+	gr := schema.GroupResource{Resource: "pods"}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "nil",
+			want: false,
+		},
+		{
+			name: "too many requests",
+			err:  apierrors.NewTooManyRequests("slow down", 1),
+			want: true,
+		},
+		{
+			name: "internal error",
+			err:  apierrors.NewInternalError(errors.New("boom")),
+			want: true,
+		},
+		{
+			name: "service unavailable",
+			err:  apierrors.NewServiceUnavailable("unavailable"),
+			want: true,
+		},
+		{
+			name: "server timeout",
+			err:  apierrors.NewServerTimeout(gr, "list", 1),
+			want: true,
+		},
+		{
+			name: "connection reset",
+			err:  fmt.Errorf("read: %w", syscall.ECONNRESET),
+			want: true,
+		},
+		{
+			name: "unexpected EOF",
+			err:  io.ErrUnexpectedEOF,
+			want: true,
+		},
+		{
+			name: "etcd timeout",
+			err:  errors.New("etcdserver: request timed out"),
+			want: true,
+		},
+		{
+			name: "forbidden",
+			err:  apierrors.NewForbidden(gr, "", errors.New("no")),
+			want: false,
+		},
+		{
+			name: "not found",
+			err:  apierrors.NewNotFound(gr, "mypod"),
+			want: false,
+		},
+		{
+			name: "canceled",
+			err:  context.Canceled,
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Errorf("isRetryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff time.Duration
+		attempt uint64
+		err     error
+		want    time.Duration
+	}{
+		{
+			name:    "first attempt",
+			backoff: time.Second,
+			attempt: 0,
+			err:     errors.New("boom"),
+			want:    time.Second,
+		},
+		{
+			name:    "exponential",
+			backoff: time.Second,
+			attempt: 3,
+			err:     errors.New("boom"),
+			want:    8 * time.Second,
+		},
+		{
+			name:    "capped",
+			backoff: time.Second,
+			attempt: 20,
+			err:     errors.New("boom"),
+			want:    maxRetryBackoff,
+		},
+		{
+			name:    "retry after",
+			backoff: time.Second,
+			attempt: 0,
+			err:     apierrors.NewTooManyRequests("slow down", 5),
+			want:    5 * time.Second,
+		},
+		{
+			name:    "retry after shorter than backoff",
+			backoff: 10 * time.Second,
+			attempt: 0,
+			err:     apierrors.NewTooManyRequests("slow down", 5),
+			want:    10 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryDelay(tt.backoff, tt.attempt, tt.err); got != tt.want {
+				t.Errorf("retryDelay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	transient := apierrors.NewInternalError(errors.New("boom"))
+	permanent := apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "", errors.New("no"))
+
+	tests := []struct {
+		name         string
+		retries      uint64
+		errs         []error
+		wantErr      error
+		wantAttempts int
+	}{
+		{
+			name:         "success",
+			retries:      3,
+			errs:         []error{nil},
+			wantAttempts: 1,
+		},
+		{
+			name:         "success after transient errors",
+			retries:      3,
+			errs:         []error{transient, transient, nil},
+			wantAttempts: 3,
+		},
+		{
+			name:         "retries exhausted",
+			retries:      2,
+			errs:         []error{transient, transient, transient, nil},
+			wantErr:      transient,
+			wantAttempts: 3,
+		},
+		{
+			name:         "permanent error",
+			retries:      3,
+			errs:         []error{permanent, nil},
+			wantErr:      permanent,
+			wantAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := withRetry(context.Background(), retryPolicy{retries: tt.retries, backoff: time.Millisecond}, func() error {
+				err := tt.errs[attempts]
+				attempts++
+				return err
+			})
+			if err != tt.wantErr {
+				t.Errorf("withRetry() error = %v, want %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("withRetry() attempts = %v, want %v", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
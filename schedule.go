@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshotFormat is the name format of the directories of scheduled dumps,
+// sortable and without characters which are invalid on Windows.
+const snapshotFormat = "2006-01-02T15-04-05Z"
+
+// latestLink points to the directory of the latest scheduled dump.
+const latestLink = "latest"
+
+type schedule interface {
+	// next returns the next activation time, later than the given time.
+	next(time.Time) time.Time
+}
+
+// everySchedule runs in a fixed interval, e.g. '@every 1h'.
+type everySchedule struct {
+	interval time.Duration
+}
+
+func (s everySchedule) next(t time.Time) time.Time {
+	return t.Add(s.interval).Truncate(time.Second)
+}
+
+// cronSchedule runs according to a standard 5-field cron expression.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64 // bit sets of the allowed values
+	domStar, dowStar              bool   // whether the field was '*', see next()
+}
+
+func (s cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// give up if no matching time can be found within 5 years (e.g. February 30)
+	yearLimit := t.Year() + 5
+
+	for t.Year() <= yearLimit {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+// dayMatches follows the cron semantic: when both day of month and day of week
+// are restricted, a day matches if either of them matches.
+func (s cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+var scheduleDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = map[string]uint64{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	dowNames   = map[string]uint64{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// parseSchedule parses a cron expression ('min hour dom month dow'),
+// a descriptor like '@daily' or an interval like '@every 1h30m'.
+func parseSchedule(spec string) (schedule, error) {
+	spec = strings.TrimSpace(spec)
+
+	if interval, ok := strings.CutPrefix(spec, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(interval))
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing interval %q: %v", interval, err)
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("interval %v is shorter than one second", d)
+		}
+		return everySchedule{interval: d}, nil
+	}
+
+	if strings.HasPrefix(spec, "@") {
+		expr, ok := scheduleDescriptors[spec]
+		if !ok {
+			return nil, fmt.Errorf("unknown descriptor %q", spec)
+		}
+		spec = expr
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields (minute hour day-of-month month day-of-week), got %d in %q", len(fields), spec)
+	}
+
+	var (
+		s   cronSchedule
+		err error
+	)
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("failed parsing minute: %v", err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("failed parsing hour: %v", err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("failed parsing day of month: %v", err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("failed parsing month: %v", err)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7, dowNames); err != nil {
+		return nil, fmt.Errorf("failed parsing day of week: %v", err)
+	}
+	// 7 is an alias for sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 << 0
+	}
+	s.domStar = fields[2] == "*" || fields[2] == "?"
+	s.dowStar = fields[4] == "*" || fields[4] == "?"
+
+	return s, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges ('1-5') and steps ('*/15', '0-30/5').
+func parseCronField(field string, lowest, highest uint64, names map[string]uint64) (uint64, error) {
+	parseValue := func(s string) (uint64, error) {
+		if v, ok := names[strings.ToLower(s)]; ok {
+			return v, nil
+		}
+		v, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid value %q", s)
+		}
+		if v < lowest || v > highest {
+			return 0, fmt.Errorf("value %d out of range [%d, %d]", v, lowest, highest)
+		}
+		return v, nil
+	}
+
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := uint64(1)
+		if hasStep {
+			var err error
+			step, err = strconv.ParseUint(stepPart, 10, 64)
+			if err != nil || step == 0 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+		}
+
+		var start, end uint64
+		switch {
+		case rangePart == "*" || rangePart == "?":
+			start, end = lowest, highest
+		case strings.Contains(rangePart, "-"):
+			from, to, _ := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = parseValue(from); err != nil {
+				return 0, err
+			}
+			if end, err = parseValue(to); err != nil {
+				return 0, err
+			}
+			if start > end {
+				return 0, fmt.Errorf("invalid range %q", rangePart)
+			}
+		default:
+			var err error
+			if start, err = parseValue(rangePart); err != nil {
+				return 0, err
+			}
+			end = start
+			if hasStep {
+				end = highest
+			}
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << v
+		}
+	}
+
+	return bits, nil
+}
+
+// dumpFunc dumps into outDir and returns whether the dump is complete and whether it failed.
+type dumpFunc func(ctx context.Context, outDir string) (complete, failed bool)
+
+// runScheduled dumps into a new timestamped subdirectory of outDir on each activation of the schedule,
+// until the stop context is done. A running dump is finished before returning, unless the abort context is done.
+func runScheduled(stop, abort context.Context, dump dumpFunc, opts options, w fileWriter, outDir string, sched schedule, retention retentionPolicy) {
+	for {
+		next := sched.next(time.Now())
+		if next.IsZero() {
+			log.Println("schedule has no further activation, stopping")
+			return
+		}
+
+		if opts.verbosity > 0 {
+			fmt.Fprintf(infoOutput, "next dump at %v\n", next.Format(time.RFC3339))
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stop.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		snapshot := next.UTC().Format(snapshotFormat)
+		runDir := filepath.Join(outDir, snapshot)
+
+		complete, failed := dump(abort, runDir)
+
+		// failed dumps don't count for the retention
+		if failed {
+			if err := markFailed(w, runDir, opts.atomicDir); err != nil {
+				log.Printf("failed marking failed dump: %v\n", err)
+			}
+		}
+
+		// only point to successful dumps
+		if complete && !failed {
+			if err := updateLatestLink(outDir, snapshot); err != nil {
+				log.Printf("failed updating %q link: %v\n", latestLink, err)
+			}
+		}
+
+		if err := applyRetention(outDir, retention, opts.verbosity); err != nil {
+			log.Printf("failed applying retention: %v\n", err)
+		}
+
+		if stop.Err() != nil {
+			return
+		}
+	}
+}
+
+// markFailed marks the dump in runDir as failed. With -atomic-dir, the failed dump was kept in
+// the staging dir, which is moved to runDir so it's removed by the retention like other snapshots.
+func markFailed(w fileWriter, runDir string, atomicDir bool) error {
+	if atomicDir {
+		staging := stagingDir(runDir)
+		if err := os.Rename(staging, runDir); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed renaming %q to %q: %v", staging, runDir, err)
+		}
+	}
+	return writeFailedMarker(w, runDir)
+}
+
+// updateLatestLink atomically points the 'latest' symlink in outDir to the given snapshot.
+func updateLatestLink(outDir, snapshot string) error {
+	link := filepath.Join(outDir, latestLink)
+	tmpLink := link + ".tmp"
+
+	if err := os.Remove(tmpLink); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Symlink(snapshot, tmpLink); err != nil {
+		return err
+	}
+	return os.Rename(tmpLink, link)
+}
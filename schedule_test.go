@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	from := time.Date(2024, time.January, 31, 10, 17, 30, 0, time.UTC) // a wednesday
+
+	tests := []struct {
+		name    string
+		spec    string
+		want    []time.Time // the next activations after 'from'
+		wantErr bool
+	}{
+		{
+			name: "every",
+			spec: "@every 1h30m",
+			want: []time.Time{
+				time.Date(2024, time.January, 31, 11, 47, 30, 0, time.UTC),
+				time.Date(2024, time.January, 31, 13, 17, 30, 0, time.UTC),
+			},
+		},
+		{
+			name: "hourly",
+			spec: "@hourly",
+			want: []time.Time{
+				time.Date(2024, time.January, 31, 11, 0, 0, 0, time.UTC),
+				time.Date(2024, time.January, 31, 12, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "daily",
+			spec: "@daily",
+			want: []time.Time{
+				time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, time.February, 2, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "step",
+			spec: "*/15 * * * *",
+			want: []time.Time{
+				time.Date(2024, time.January, 31, 10, 30, 0, 0, time.UTC),
+				time.Date(2024, time.January, 31, 10, 45, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "list and range",
+			spec: "0 3,15 * * mon-fri",
+			want: []time.Time{
+				time.Date(2024, time.January, 31, 15, 0, 0, 0, time.UTC),
+				time.Date(2024, time.February, 1, 3, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "sunday as 7",
+			spec: "0 0 * * 7",
+			want: []time.Time{
+				time.Date(2024, time.February, 4, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "day of month or day of week",
+			spec: "0 0 1 * sat",
+			want: []time.Time{
+				time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, time.February, 3, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "leap day",
+			spec: "0 0 29 feb *",
+			want: []time.Time{
+				time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC),
+				time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "never",
+			spec: "0 0 30 feb *",
+			want: []time.Time{{}},
+		},
+		{
+			name:    "too few fields",
+			spec:    "0 0 * *",
+			wantErr: true,
+		},
+		{
+			name:    "out of range",
+			spec:    "60 * * * *",
+			wantErr: true,
+		},
+		{
+			name:    "unknown descriptor",
+			spec:    "@sometimes",
+			wantErr: true,
+		},
+		{
+			name:    "invalid interval",
+			spec:    "@every often",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sched, err := parseSchedule(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			current := from
+			for _, want := range tt.want {
+				got := sched.next(current)
+				if !got.Equal(want) {
+					t.Fatalf("next(%v) = %v, want %v", current, got, want)
+				}
+				current = got
+			}
+		})
+	}
+}
+
+func TestUpdateLatestLink(t *testing.T) {
+	outDir := t.TempDir()
+
+	for _, snapshot := range []string{"2024-01-01T00-00-00Z", "2024-01-02T00-00-00Z"} {
+		if err := updateLatestLink(outDir, snapshot); err != nil {
+			t.Fatalf("updateLatestLink() error = %v", err)
+		}
+
+		got, err := os.Readlink(filepath.Join(outDir, latestLink))
+		if err != nil {
+			t.Fatalf("failed reading link: %v", err)
+		}
+		if got != snapshot {
+			t.Errorf("got link to %q, want %q", got, snapshot)
+		}
+	}
+}
+
+// listSchedule activates at the given times, which are in the past and run immediately.
+type listSchedule struct {
+	times []time.Time
+}
+
+func (s *listSchedule) next(time.Time) time.Time {
+	if len(s.times) == 0 {
+		return time.Time{}
+	}
+	next := s.times[0]
+	s.times = s.times[1:]
+	return next
+}
+
+func TestRunScheduledAtomicDir(t *testing.T) {
+	outDir := t.TempDir()
+	w := fileWriter{fileMode: 0o600, dirMode: 0o700, uid: -1, gid: -1}
+
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	sched := &listSchedule{}
+	for i := range 4 {
+		sched.times = append(sched.times, start.Add(time.Duration(i)*time.Hour))
+	}
+
+	// the first run succeeds, the others fail and keep their dump in the staging dir like dumper.dumpTo
+	var runs int
+	dump := func(ctx context.Context, runDir string) (bool, bool) {
+		runs++
+		if err := w.writeFile(filepath.Join(stagingDir(runDir), reportFilename), []byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+		if runs > 1 {
+			return true, true
+		}
+		if err := swapDir(stagingDir(runDir), runDir); err != nil {
+			t.Fatal(err)
+		}
+		return true, false
+	}
+
+	opts := options{atomicDir: true}
+	runScheduled(context.Background(), context.Background(), dump, opts, w, outDir, sched, retentionPolicy{keepLast: 1})
+
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.Name())
+	}
+
+	// the successful and the newest failed dump, without staging dirs
+	want := []string{"2024-01-01T00-00-00Z", "2024-01-01T03-00-00Z", latestLink}
+	if !slices.Equal(got, want) {
+		t.Errorf("got entries %v, want %v", got, want)
+	}
+
+	if _, err := os.Stat(filepath.Join(outDir, "2024-01-01T03-00-00Z", failedMarker)); err != nil {
+		t.Errorf("failed dump isn't marked: %v", err)
+	}
+
+	link, err := os.Readlink(filepath.Join(outDir, latestLink))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link != "2024-01-01T00-00-00Z" {
+		t.Errorf("got link to %q, want the successful dump", link)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// suffixes of the directories used by -atomic-dir next to the output directory
+const (
+	stagingSuffix  = ".staging"
+	previousSuffix = ".prev"
+)
+
+// fileWriter creates the files and directories of a dump with the configured permissions and owner.
+type fileWriter struct {
+	fileMode os.FileMode
+	dirMode  os.FileMode
+	uid      int // -1 to keep the owner
+	gid      int // -1 to keep the group
+
+	// keepUnchanged doesn't rewrite files with the same content, which keeps their modification
+	// time for -incremental, e.g. of the CRD index or the helm releases written on each dump.
+	keepUnchanged bool
+}
+
+// writeFile atomically writes the file, including missing parent directories.
+func (w fileWriter) writeFile(filename string, data []byte) error {
+	if w.keepUnchanged {
+		if existing, err := os.ReadFile(filename); err == nil && bytes.Equal(existing, data) {
+			return nil
+		}
+	}
+
+	if err := w.mkdirAll(filepath.Dir(filename)); err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(filename, data, w.fileMode); err != nil {
+		return fmt.Errorf("failed writing file %q: %v", filename, err)
+	}
+
+	if err := w.chown(filename); err != nil {
+		return fmt.Errorf("failed changing owner of %q: %v", filename, err)
+	}
+
+	return nil
+}
+
+// mkdirAll is like os.MkdirAll, but applies the mode regardless of the umask and changes the owner of created directories.
+func (w fileWriter) mkdirAll(dir string) error {
+	info, err := os.Stat(dir)
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("failed creating dir %q: not a directory", dir)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return fmt.Errorf("failed creating dir %q: %v", dir, err)
+	}
+
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := w.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+
+	// the directory might have been created concurrently in the meantime
+	if err := os.Mkdir(dir, w.dirMode); err != nil {
+		if os.IsExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed creating dir %q: %v", dir, err)
+	}
+
+	if err := os.Chmod(dir, w.dirMode); err != nil {
+		return fmt.Errorf("failed changing mode of %q: %v", dir, err)
+	}
+
+	if err := w.chown(dir); err != nil {
+		return fmt.Errorf("failed changing owner of %q: %v", dir, err)
+	}
+
+	return nil
+}
+
+func (w fileWriter) chown(name string) error {
+	if w.uid < 0 && w.gid < 0 {
+		return nil
+	}
+	return os.Chown(name, w.uid, w.gid)
+}
+
+// parseFileMode parses an octal permission like '0600'.
+func parseFileMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid octal mode %q", s)
+	}
+	if mode > 0o777 {
+		return 0, fmt.Errorf("mode %q exceeds 0777", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// parseOwner parses 'uid:gid' or 'uid', empty for keeping the owner.
+func parseOwner(s string) (uid, gid int, err error) {
+	if s == "" {
+		return -1, -1, nil
+	}
+
+	uidStr, gidStr, hasGID := strings.Cut(s, ":")
+	if uid, err = strconv.Atoi(uidStr); err != nil || uid < 0 {
+		return 0, 0, errors.New("invalid uid " + strconv.Quote(uidStr))
+	}
+
+	gid = -1
+	if hasGID {
+		if gid, err = strconv.Atoi(gidStr); err != nil || gid < 0 {
+			return 0, 0, errors.New("invalid gid " + strconv.Quote(gidStr))
+		}
+	}
+
+	return uid, gid, nil
+}
+
+// writeFileAtomic writes the data to a temporary file in the same directory and renames it to filename,
+// so readers never observe a partially written file and a failed write keeps the previous content.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(filename), ".kubedump-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmpFile.Name()
+
+	// remove the temporary file, unless it was renamed
+	defer os.Remove(tmpName)
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Chmod(perm); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpName, filename)
+}
+
+// stagingDir returns the directory where the dump for outDir is built with -atomic-dir.
+func stagingDir(outDir string) string {
+	return filepath.Clean(outDir) + stagingSuffix
+}
+
+// swapDir replaces outDir with the staging directory and keeps the replaced directory with the previous suffix.
+func swapDir(staging, outDir string) error {
+	outDir = filepath.Clean(outDir)
+	previous := outDir + previousSuffix
+
+	if err := os.RemoveAll(previous); err != nil {
+		return fmt.Errorf("failed removing %q: %v", previous, err)
+	}
+
+	if err := os.Rename(outDir, previous); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed renaming %q to %q: %v", outDir, previous, err)
+	}
+
+	if err := os.Rename(staging, outDir); err != nil {
+		return fmt.Errorf("failed renaming %q to %q: %v", staging, outDir, err)
+	}
+
+	return nil
+}
+
+// validAtomicDir reports whether outDir can be swapped, i.e. it's not the current, a parent or the root directory.
+func validAtomicDir(outDir string) bool {
+	cleaned := filepath.Clean(outDir)
+	base := filepath.Base(cleaned)
+	return base != "." && base != ".." && filepath.Dir(cleaned) != cleaned
+}
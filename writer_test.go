@@ -0,0 +1,251 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "file.yaml")
+
+	for _, content := range []string{"first", "second"} {
+		if err := writeFileAtomic(filename, []byte(content), 0o640); err != nil {
+			t.Fatalf("writeFileAtomic() error = %v", err)
+		}
+
+		got, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("got content %q, want %q", got, content)
+		}
+	}
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d files, want 1", len(entries))
+	}
+}
+
+func TestWriteFileKeepUnchanged(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "crds.yaml")
+	w := fileWriter{fileMode: 0o600, dirMode: 0o700, uid: -1, gid: -1, keepUnchanged: true}
+
+	if err := w.writeFile(filename, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(filename, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	modTime := func() time.Time {
+		t.Helper()
+		info, err := os.Stat(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.ModTime()
+	}
+
+	// the same content isn't rewritten
+	if err := w.writeFile(filename, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if got := modTime(); !got.Equal(old) {
+		t.Errorf("unchanged file was rewritten at %v", got)
+	}
+
+	if err := w.writeFile(filename, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if got := modTime(); got.Equal(old) {
+		t.Error("changed file wasn't rewritten")
+	}
+	if got, err := os.ReadFile(filename); err != nil || string(got) != "second" {
+		t.Errorf("got content %q, err %v, want second", got, err)
+	}
+}
+
+func TestSwapDir(t *testing.T) {
+	base := t.TempDir()
+	outDir := filepath.Join(base, "dump")
+
+	writeMarker := func(dir, content string) {
+		t.Helper()
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "marker"), []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readMarker := func(dir string) string {
+		t.Helper()
+		content, err := os.ReadFile(filepath.Join(dir, "marker"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	// first run without an existing output dir
+	writeMarker(stagingDir(outDir), "run1")
+	if err := swapDir(stagingDir(outDir), outDir); err != nil {
+		t.Fatalf("swapDir() error = %v", err)
+	}
+	if got := readMarker(outDir); got != "run1" {
+		t.Errorf("got %q, want run1", got)
+	}
+
+	// the second and third run keep the previous dump
+	for _, run := range []string{"run2", "run3"} {
+		previous := readMarker(outDir)
+
+		writeMarker(stagingDir(outDir), run)
+		if err := swapDir(stagingDir(outDir), outDir); err != nil {
+			t.Fatalf("swapDir() error = %v", err)
+		}
+		if got := readMarker(outDir); got != run {
+			t.Errorf("got %q, want %q", got, run)
+		}
+		if got := readMarker(outDir + previousSuffix); got != previous {
+			t.Errorf("got previous %q, want %q", got, previous)
+		}
+	}
+
+	if _, err := os.Stat(stagingDir(outDir)); !os.IsNotExist(err) {
+		t.Errorf("staging dir still exists: %v", err)
+	}
+}
+
+func TestValidAtomicDir(t *testing.T) {
+	tests := []struct {
+		dir  string
+		want bool
+	}{
+		{dir: "dump", want: true},
+		{dir: "dump/", want: true},
+		{dir: "/var/dump", want: true},
+		{dir: ".", want: false},
+		{dir: "./", want: false},
+		{dir: "..", want: false},
+		{dir: "../..", want: false},
+		{dir: "/", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			if got := validAtomicDir(tt.dir); got != tt.want {
+				t.Errorf("validAtomicDir(%q) = %v, want %v", tt.dir, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileWriter(t *testing.T) {
+	dir := t.TempDir()
+	w := fileWriter{fileMode: 0o600, dirMode: 0o700, uid: -1, gid: -1}
+
+	filename := filepath.Join(dir, "a", "b", "file.yaml")
+	if err := w.writeFile(filename, []byte("content")); err != nil {
+		t.Fatalf("writeFile() error = %v", err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		want os.FileMode
+	}{
+		{name: filepath.Join(dir, "a"), want: 0o700},
+		{name: filepath.Join(dir, "a", "b"), want: 0o700},
+		{name: filename, want: 0o600},
+	} {
+		info, err := os.Stat(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != tt.want {
+			t.Errorf("%v: got mode %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// existing directories are kept as they are
+	if err := os.Chmod(filepath.Join(dir, "a"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.writeFile(filepath.Join(dir, "a", "other.yaml"), []byte("content")); err != nil {
+		t.Fatalf("writeFile() error = %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.Mode().Perm(); got != 0o755 {
+		t.Errorf("got mode %v of existing dir, want %v", got, os.FileMode(0o755))
+	}
+}
+
+func TestParseFileMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{mode: "0600", want: 0o600},
+		{mode: "755", want: 0o755},
+		{mode: "0", want: 0},
+		{mode: "0800", wantErr: true},
+		{mode: "1777", wantErr: true},
+		{mode: "rw", wantErr: true},
+		{mode: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			got, err := parseFileMode(tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFileMode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseFileMode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOwner(t *testing.T) {
+	tests := []struct {
+		owner   string
+		wantUID int
+		wantGID int
+		wantErr bool
+	}{
+		{owner: "", wantUID: -1, wantGID: -1},
+		{owner: "1000", wantUID: 1000, wantGID: -1},
+		{owner: "1000:2000", wantUID: 1000, wantGID: 2000},
+		{owner: "0:0", wantUID: 0, wantGID: 0},
+		{owner: "root", wantErr: true},
+		{owner: "1000:", wantErr: true},
+		{owner: "-1:0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.owner, func(t *testing.T) {
+			uid, gid, err := parseOwner(tt.owner)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseOwner() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if uid != tt.wantUID || gid != tt.wantGID {
+				t.Errorf("parseOwner() = %d:%d, want %d:%d", uid, gid, tt.wantUID, tt.wantGID)
+			}
+		})
+	}
+}